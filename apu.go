@@ -0,0 +1,501 @@
+package goboy
+
+// DefaultSampleRate is the audio output rate used unless configured otherwise
+const DefaultSampleRate = 44100
+
+// cyclesPerSecond is the number of M-cycles per second
+const cyclesPerSecond = 1 << 20
+
+// Bits of the sound registers always read as 1, from NR10 (0xff10) to NR52 (0xff26)
+var soundReadMasks = [0x17]byte{
+	0x80, 0x3f, 0x00, 0xff, 0xbf, // NR10-NR14
+	0xff, 0x3f, 0x00, 0xff, 0xbf, // NR20-NR24
+	0x7f, 0xff, 0x9f, 0xff, 0xbf, // NR30-NR34
+	0xff, 0xff, 0x00, 0x00, 0xbf, // NR40-NR44
+	0x00, 0x00, 0x70, // NR50-NR52
+}
+
+var dutyPatterns = [4]byte{0x01, 0x81, 0x87, 0x7e}
+
+var noiseDivisors = [8]int{8, 16, 32, 48, 64, 80, 96, 112}
+
+// envelope changes the volume of a channel over time
+type envelope struct {
+	initial  byte
+	increase bool
+	period   byte
+	volume   byte
+	counter  byte
+}
+
+func (e *envelope) write(value byte) {
+	e.initial = value >> 4
+	e.increase = value&0x08 != 0
+	e.period = value & 7
+}
+
+func (e *envelope) trigger() {
+	e.volume = e.initial
+	e.counter = e.period
+}
+
+func (e *envelope) clock() {
+	if e.period == 0 {
+		return
+	}
+	e.counter--
+	if e.counter > 0 {
+		return
+	}
+	e.counter = e.period
+	if e.increase && e.volume < 15 {
+		e.volume++
+	} else if !e.increase && e.volume > 0 {
+		e.volume--
+	}
+}
+
+// channel holds what is common to the four sound channels
+type channel struct {
+	enabled       bool
+	dac           bool
+	length        int
+	lengthEnabled bool
+	frequency     uint16
+	timer         int
+}
+
+func (c *channel) clockLength() {
+	if c.lengthEnabled && c.length > 0 {
+		c.length--
+		if c.length == 0 {
+			c.enabled = false
+		}
+	}
+}
+
+// square is a square wave channel, channel 1 also has a frequency sweep
+type square struct {
+	channel
+	envelope
+	duty     byte
+	dutyStep uint
+
+	sweepPeriod  byte
+	sweepNegate  bool
+	sweepShift   byte
+	sweepTimer   byte
+	sweepEnabled bool
+	shadow       uint16
+}
+
+func (s *square) advance(cycles int) {
+	s.timer -= cycles
+	for s.timer <= 0 {
+		s.timer += int(2048-s.frequency) * 4
+		s.dutyStep = (s.dutyStep + 1) & 7
+	}
+}
+
+func (s *square) output() byte {
+	if !s.enabled || (dutyPatterns[s.duty]>>s.dutyStep)&1 == 0 {
+		return 0
+	}
+	return s.volume
+}
+
+func (s *square) trigger() {
+	s.enabled = s.dac
+	if s.length == 0 {
+		s.length = 64
+	}
+	s.timer = int(2048-s.frequency) * 4
+	s.envelope.trigger()
+
+	s.shadow = s.frequency
+	s.sweepTimer = s.sweepPeriod
+	if s.sweepTimer == 0 {
+		s.sweepTimer = 8
+	}
+	s.sweepEnabled = s.sweepPeriod != 0 || s.sweepShift != 0
+	if s.sweepShift != 0 {
+		s.sweepFrequency()
+	}
+}
+
+// sweepFrequency computes the next frequency of the sweep,
+// the channel is disabled when it overflows
+func (s *square) sweepFrequency() uint16 {
+	delta := s.shadow >> s.sweepShift
+	frequency := s.shadow + delta
+	if s.sweepNegate {
+		frequency = s.shadow - delta
+	}
+	if frequency > 2047 {
+		s.enabled = false
+	}
+	return frequency
+}
+
+func (s *square) clockSweep() {
+	s.sweepTimer--
+	if s.sweepTimer > 0 {
+		return
+	}
+	s.sweepTimer = s.sweepPeriod
+	if s.sweepTimer == 0 {
+		s.sweepTimer = 8
+	}
+	if !s.sweepEnabled || s.sweepPeriod == 0 {
+		return
+	}
+	frequency := s.sweepFrequency()
+	if frequency <= 2047 && s.sweepShift != 0 {
+		s.frequency = frequency
+		s.shadow = frequency
+		s.sweepFrequency()
+	}
+}
+
+// wave plays the 32 samples of the wave RAM
+type wave struct {
+	channel
+	volumeShift byte
+	position    uint
+	ram         [16]byte
+}
+
+func (w *wave) advance(cycles int) {
+	w.timer -= cycles
+	for w.timer <= 0 {
+		w.timer += int(2048-w.frequency) * 2
+		w.position = (w.position + 1) & 31
+	}
+}
+
+func (w *wave) output() byte {
+	if !w.enabled {
+		return 0
+	}
+	sample := w.ram[w.position/2]
+	if w.position&1 == 0 {
+		sample >>= 4
+	}
+	return (sample & 0xf) >> w.volumeShift
+}
+
+func (w *wave) trigger() {
+	w.enabled = w.dac
+	if w.length == 0 {
+		w.length = 256
+	}
+	w.timer = int(2048-w.frequency) * 2
+	w.position = 0
+}
+
+// noise outputs the bits of a linear feedback shift register
+type noise struct {
+	channel
+	envelope
+	shift   byte
+	width7  bool
+	divisor byte
+	lfsr    uint16
+}
+
+func (n *noise) period() int {
+	return noiseDivisors[n.divisor] << n.shift
+}
+
+func (n *noise) advance(cycles int) {
+	n.timer -= cycles
+	for n.timer <= 0 {
+		n.timer += n.period()
+		feedback := (n.lfsr ^ n.lfsr>>1) & 1
+		n.lfsr = n.lfsr>>1 | feedback<<14
+		if n.width7 {
+			n.lfsr = n.lfsr&^0x40 | feedback<<6
+		}
+	}
+}
+
+func (n *noise) output() byte {
+	if !n.enabled || n.lfsr&1 != 0 {
+		return 0
+	}
+	return n.volume
+}
+
+func (n *noise) trigger() {
+	n.enabled = n.dac
+	if n.length == 0 {
+		n.length = 64
+	}
+	n.timer = n.period()
+	n.envelope.trigger()
+	n.lfsr = 0x7fff
+}
+
+// apu emulates the Audio Processing Unit
+type apu struct {
+	timer *timer // the frame sequencer is clocked by DIV
+	speed *speed // the channels do not follow the double speed of the CPU
+	model Model
+
+	regs    [0x17]byte // last values written, for the reads
+	enabled bool
+	ch1     square
+	ch2     square
+	ch3     wave
+	ch4     noise
+
+	frameStep int
+	divBit    bool
+
+	sampleRate  int
+	sampleClock int
+	samples     []int16 // interleaved stereo samples, left first
+}
+
+func (a *apu) step() {
+	dots := a.speed.dots()
+	if a.enabled {
+		a.ch1.advance(dots)
+		a.ch2.advance(dots)
+		a.ch3.advance(dots)
+		a.ch4.advance(dots)
+	}
+
+	// The frame sequencer runs on the falling edges of the bit 4 of DIV,
+	// the bit 5 in double speed
+	sequencerBit := uint(12)
+	if dots == 2 {
+		sequencerBit = 13
+	}
+	bit := a.timer.counter&(1<<sequencerBit) != 0
+	if a.divBit && !bit && a.enabled {
+		a.clockFrameSequencer()
+	}
+	a.divBit = bit
+
+	a.sampleClock += a.sampleRate * dots
+	if a.sampleClock >= cyclesPerSecond*4 {
+		a.sampleClock -= cyclesPerSecond * 4
+		a.mix()
+	}
+}
+
+func (a *apu) clockFrameSequencer() {
+	if a.frameStep%2 == 0 {
+		a.ch1.clockLength()
+		a.ch2.clockLength()
+		a.ch3.clockLength()
+		a.ch4.clockLength()
+	}
+	if a.frameStep == 2 || a.frameStep == 6 {
+		a.ch1.clockSweep()
+	}
+	if a.frameStep == 7 {
+		a.ch1.envelope.clock()
+		a.ch2.envelope.clock()
+		a.ch4.envelope.clock()
+	}
+	a.frameStep = (a.frameStep + 1) & 7
+}
+
+// maxSamples bounds the samples kept when nobody reads them (one second)
+func (a *apu) maxSamples() int {
+	return a.sampleRate * 2
+}
+
+// mix produces a stereo sample from the outputs of the channels
+func (a *apu) mix() {
+	if len(a.samples) >= a.maxSamples() {
+		return
+	}
+	outputs := [4]byte{a.ch1.output(), a.ch2.output(), a.ch3.output(), a.ch4.output()}
+	dacs := [4]bool{a.ch1.dac, a.ch2.dac, a.ch3.dac, a.ch4.dac}
+	panning := a.regs[0x15]
+	volumes := a.regs[0x14]
+
+	var left, right int
+	for i, output := range outputs {
+		if !a.enabled || !dacs[i] {
+			continue
+		}
+		analog := int(output)*2 - 15 // the DAC maps 0-15 to -15..15
+		if panning&(0x10<<uint(i)) != 0 {
+			left += analog
+		}
+		if panning&(1<<uint(i)) != 0 {
+			right += analog
+		}
+	}
+	left *= int((volumes>>4)&7) + 1
+	right *= int(volumes&7) + 1
+	// 4 channels at full volume give 15*4*8 = 480
+	a.samples = append(a.samples, int16(left*64), int16(right*64))
+}
+
+// drainSamples returns the samples produced since the last call
+func (a *apu) drainSamples() []int16 {
+	samples := a.samples
+	a.samples = nil
+	return samples
+}
+
+func (a *apu) read(address uint16) byte {
+	switch {
+	case address < 0xff27:
+		i := address - 0xff10
+		value := a.regs[i] | soundReadMasks[i]
+		if address == 0xff26 {
+			value = a.regs[i]&0x80 | soundReadMasks[i]
+			for n, enabled := range []bool{a.ch1.enabled, a.ch2.enabled, a.ch3.enabled, a.ch4.enabled} {
+				if enabled {
+					value |= 1 << uint(n)
+				}
+			}
+		}
+		return value
+	case 0xff30 <= address && address < 0xff40:
+		if i, ok := a.waveIndex(address); ok {
+			return a.ch3.ram[i]
+		}
+		return 0xff
+	default:
+		return 0xff
+	}
+}
+
+func (a *apu) write(address uint16, value byte) {
+	switch {
+	case 0xff30 <= address && address < 0xff40: // Wave RAM is accessible even when off
+		if i, ok := a.waveIndex(address); ok {
+			a.ch3.ram[i] = value
+		}
+		return
+	case address == 0xff26:
+		a.setPower(value&0x80 != 0)
+		return
+	case address >= 0xff27:
+		return
+	case !a.enabled:
+		if !a.model.cgb() { // the DMG still loads the length counters
+			a.writeLength(address, value)
+		}
+		return
+	}
+	a.regs[address-0xff10] = value
+	a.writeLength(address, value)
+
+	switch address {
+	case 0xff10: // NR10
+		a.ch1.sweepPeriod = (value >> 4) & 7
+		a.ch1.sweepNegate = value&0x08 != 0
+		a.ch1.sweepShift = value & 7
+	case 0xff11: // NR11
+		a.ch1.duty = value >> 6
+	case 0xff12: // NR12
+		a.ch1.envelope.write(value)
+		a.ch1.dac = value&0xf8 != 0
+		a.ch1.enabled = a.ch1.enabled && a.ch1.dac
+	case 0xff13: // NR13
+		a.ch1.frequency = a.ch1.frequency&0x700 | uint16(value)
+	case 0xff14: // NR14
+		a.ch1.frequency = a.ch1.frequency&0xff | uint16(value&7)<<8
+		a.ch1.lengthEnabled = value&0x40 != 0
+		if value&0x80 != 0 {
+			a.ch1.trigger()
+		}
+	case 0xff16: // NR21
+		a.ch2.duty = value >> 6
+	case 0xff17: // NR22
+		a.ch2.envelope.write(value)
+		a.ch2.dac = value&0xf8 != 0
+		a.ch2.enabled = a.ch2.enabled && a.ch2.dac
+	case 0xff18: // NR23
+		a.ch2.frequency = a.ch2.frequency&0x700 | uint16(value)
+	case 0xff19: // NR24
+		a.ch2.frequency = a.ch2.frequency&0xff | uint16(value&7)<<8
+		a.ch2.lengthEnabled = value&0x40 != 0
+		if value&0x80 != 0 {
+			a.ch2.trigger()
+		}
+	case 0xff1a: // NR30
+		a.ch3.dac = value&0x80 != 0
+		a.ch3.enabled = a.ch3.enabled && a.ch3.dac
+	case 0xff1b: // NR31
+	case 0xff1c: // NR32
+		a.ch3.volumeShift = [4]byte{4, 0, 1, 2}[(value>>5)&3]
+	case 0xff1d: // NR33
+		a.ch3.frequency = a.ch3.frequency&0x700 | uint16(value)
+	case 0xff1e: // NR34
+		a.ch3.frequency = a.ch3.frequency&0xff | uint16(value&7)<<8
+		a.ch3.lengthEnabled = value&0x40 != 0
+		if value&0x80 != 0 {
+			a.ch3.trigger()
+		}
+	case 0xff21: // NR42
+		a.ch4.envelope.write(value)
+		a.ch4.dac = value&0xf8 != 0
+		a.ch4.enabled = a.ch4.enabled && a.ch4.dac
+	case 0xff22: // NR43
+		a.ch4.shift = value >> 4
+		a.ch4.width7 = value&0x08 != 0
+		a.ch4.divisor = value & 7
+	case 0xff23: // NR44
+		a.ch4.lengthEnabled = value&0x40 != 0
+		if value&0x80 != 0 {
+			a.ch4.trigger()
+		}
+	}
+}
+
+// writeLength loads the length counter of a channel from NRx1
+func (a *apu) writeLength(address uint16, value byte) {
+	switch address {
+	case 0xff11: // NR11
+		a.ch1.length = 64 - int(value&0x3f)
+	case 0xff16: // NR21
+		a.ch2.length = 64 - int(value&0x3f)
+	case 0xff1b: // NR31
+		a.ch3.length = 256 - int(value)
+	case 0xff20: // NR41
+		a.ch4.length = 64 - int(value&0x3f)
+	}
+}
+
+// waveIndex returns the byte of wave RAM accessed by the CPU. While
+// channel 3 plays, the CGB accesses the byte being played, and the DMG
+// almost never gets the access.
+func (a *apu) waveIndex(address uint16) (int, bool) {
+	if !a.ch3.enabled {
+		return int(address - 0xff30), true
+	}
+	return int(a.ch3.position / 2), a.model.cgb()
+}
+
+// setPower turns the APU on or off, which resets all the registers.
+// The DMG keeps the length counters.
+func (a *apu) setPower(on bool) {
+	if a.enabled && !on {
+		ram := a.ch3.ram
+		lengths := [4]int{a.ch1.length, a.ch2.length, a.ch3.length, a.ch4.length}
+		a.regs = [0x17]byte{}
+		a.ch1, a.ch2, a.ch4 = square{}, square{}, noise{}
+		a.ch3 = wave{ram: ram}
+		if !a.model.cgb() {
+			a.ch1.length, a.ch2.length, a.ch3.length, a.ch4.length = lengths[0], lengths[1], lengths[2], lengths[3]
+		}
+	}
+	if !a.enabled && on {
+		a.frameStep = 0
+	}
+	a.enabled = on
+	if on {
+		a.regs[0x16] = 0x80
+	} else {
+		a.regs[0x16] = 0
+	}
+}
@@ -0,0 +1,89 @@
+package goboy
+
+import "testing"
+
+func newTestAPU() *apu {
+	a := &apu{timer: &timer{irq: &interrupts{}}, sampleRate: DefaultSampleRate}
+	a.write(0xff26, 0x80)
+	return a
+}
+
+// runCycles steps the APU and the timer clocking its frame sequencer
+func (a *apu) runCycles(cycles int) {
+	for i := 0; i < cycles; i++ {
+		a.timer.step()
+		a.step()
+	}
+}
+
+func TestSoundRegistersReadBack(t *testing.T) {
+	a := newTestAPU()
+	a.write(0xff11, 0x80)
+	a.write(0xff13, 0x12)
+	if value := a.read(0xff11); value != 0xbf {
+		t.Errorf("Expected NR11=0xbf, got %#02x", value)
+	}
+	if value := a.read(0xff13); value != 0xff {
+		t.Errorf("Expected NR13 to be write only, got %#02x", value)
+	}
+	a.write(0xff26, 0)
+	a.write(0xff12, 0xf0) // ignored while powered off
+	if value := a.read(0xff12); value != 0 {
+		t.Errorf("Expected NR12 to be reset, got %#02x", value)
+	}
+}
+
+func TestLengthCounter(t *testing.T) {
+	a := newTestAPU()
+	a.write(0xff17, 0xf0) // DAC on
+	a.write(0xff16, 62)   // length of 2
+	a.write(0xff19, 0xc0) // trigger with length enabled
+	if a.read(0xff26)&0x02 == 0 {
+		t.Fatal("Expected channel 2 to be on")
+	}
+	a.runCycles(cyclesPerSecond / 256 * 2) // 2 length clocks at 256 Hz
+	if a.read(0xff26)&0x02 != 0 {
+		t.Error("Expected channel 2 to be off after its length")
+	}
+}
+
+func TestSweepOverflow(t *testing.T) {
+	a := newTestAPU()
+	a.write(0xff12, 0xf0)
+	a.write(0xff10, 0x11) // period 1, shift 1
+	a.write(0xff13, 0x00)
+	a.write(0xff14, 0x85) // trigger at 0x500, the sweep goes to 0x780 then overflows
+	if a.read(0xff26)&0x01 == 0 {
+		t.Fatal("Expected channel 1 to be on")
+	}
+	a.runCycles(cyclesPerSecond / 128 * 2)
+	if a.read(0xff26)&0x01 != 0 {
+		t.Error("Expected channel 1 to be disabled by the sweep overflow")
+	}
+}
+
+func TestSampleOutput(t *testing.T) {
+	a := newTestAPU()
+	a.write(0xff24, 0x77)
+	a.write(0xff25, 0x10) // channel 1 on the left only
+	a.write(0xff12, 0xf0)
+	a.write(0xff11, 0x80)
+	a.write(0xff14, 0x87)
+	a.runCycles(cyclesPerSecond / 10)
+
+	samples := a.drainSamples()
+	if n := len(samples) / 2; n < DefaultSampleRate/10-1 || n > DefaultSampleRate/10 {
+		t.Fatal("Expected 4410 stereo samples, got", n)
+	}
+	var high, low bool
+	for i := 0; i < len(samples); i += 2 {
+		high = high || samples[i] > 0
+		low = low || samples[i] < 0
+		if samples[i+1] != 0 {
+			t.Fatal("Expected silence on the right")
+		}
+	}
+	if !high || !low {
+		t.Error("Expected a square wave on the left")
+	}
+}
@@ -0,0 +1,124 @@
+package goboy
+
+// Sizes of the boot ROMs
+const (
+	bootROMSize    = 0x100 // DMG0, DMG, MGB, SGB and SGB2
+	cgbBootROMSize = 0x900 // CGB and AGB, 0x100-0x1ff shows the cartridge header
+)
+
+// dmgBootROM is the boot ROM of the DMG, the default one
+var dmgBootROM = [bootROMSize]byte{
+	0x31, 0xfe, 0xff, 0xaf, 0x21, 0xff, 0x9f, 0x32, 0xcb, 0x7c, 0x20, 0xfb, 0x21, 0x26, 0xff, 0x0e,
+	0x11, 0x3e, 0x80, 0x32, 0xe2, 0x0c, 0x3e, 0xf3, 0xe2, 0x32, 0x3e, 0x77, 0x77, 0x3e, 0xfc, 0xe0,
+	0x47, 0x11, 0x04, 0x01, 0x21, 0x10, 0x80, 0x1a, 0xcd, 0x95, 0x00, 0xcd, 0x96, 0x00, 0x13, 0x7b,
+	0xfe, 0x34, 0x20, 0xf3, 0x11, 0xd8, 0x00, 0x06, 0x08, 0x1a, 0x13, 0x22, 0x23, 0x05, 0x20, 0xf9,
+	0x3e, 0x19, 0xea, 0x10, 0x99, 0x21, 0x2f, 0x99, 0x0e, 0x0c, 0x3d, 0x28, 0x08, 0x32, 0x0d, 0x20,
+	0xf9, 0x2e, 0x0f, 0x18, 0xf3, 0x67, 0x3e, 0x64, 0x57, 0xe0, 0x42, 0x3e, 0x91, 0xe0, 0x40, 0x04,
+	0x1e, 0x02, 0x0e, 0x0c, 0xf0, 0x44, 0xfe, 0x90, 0x20, 0xfa, 0x0d, 0x20, 0xf7, 0x1d, 0x20, 0xf2,
+	0x0e, 0x13, 0x24, 0x7c, 0x1e, 0x83, 0xfe, 0x62, 0x28, 0x06, 0x1e, 0xc1, 0xfe, 0x64, 0x20, 0x06,
+	0x7b, 0xe2, 0x0c, 0x3e, 0x87, 0xe2, 0xf0, 0x42, 0x90, 0xe0, 0x42, 0x15, 0x20, 0xd2, 0x05, 0x20,
+	0x4f, 0x16, 0x20, 0x18, 0xcb, 0x4f, 0x06, 0x04, 0xc5, 0xcb, 0x11, 0x17, 0xc1, 0xcb, 0x11, 0x17,
+	0x05, 0x20, 0xf5, 0x22, 0x23, 0x22, 0x23, 0xc9, 0xce, 0xed, 0x66, 0x66, 0xcc, 0x0d, 0x00, 0x0b,
+	0x03, 0x73, 0x00, 0x83, 0x00, 0x0c, 0x00, 0x0d, 0x00, 0x08, 0x11, 0x1f, 0x88, 0x89, 0x00, 0x0e,
+	0xdc, 0xcc, 0x6e, 0xe6, 0xdd, 0xdd, 0xd9, 0x99, 0xbb, 0xbb, 0x67, 0x63, 0x6e, 0x0e, 0xec, 0xcc,
+	0xdd, 0xdc, 0x99, 0x9f, 0xbb, 0xb9, 0x33, 0x3e, 0x3c, 0x42, 0xb9, 0xa5, 0xb9, 0xa5, 0x42, 0x3c,
+	0x21, 0x04, 0x01, 0x11, 0xa8, 0x00, 0x1a, 0x13, 0xbe, 0x20, 0xfe, 0x23, 0x7d, 0xfe, 0x34, 0x20,
+	0xf5, 0x06, 0x19, 0x78, 0x86, 0x23, 0x05, 0x20, 0xfb, 0x86, 0x20, 0xfe, 0x3e, 0x01, 0xe0, 0x50}
+
+// bootMapped tells if the boot ROM hides the cartridge at an address
+func (m *memory) bootMapped(address uint16) bool {
+	return m.boot != nil && (address < 0x100 || (address >= 0x200 && int(address) < len(m.boot)))
+}
+
+// Colors left by the CGB boot ROM for the DMG games it does not know,
+// BG then OBJ0 and OBJ1
+var compatPalettes = [3][4]uint16{
+	{0x7fff, 0x1bef, 0x6180, 0x0000},
+	{0x7fff, 0x421f, 0x1cf2, 0x0000},
+	{0x7fff, 0x421f, 0x1cf2, 0x0000},
+}
+
+// titleChecksum returns the sum of the title bytes computed by the CGB
+// boot ROM to colorize the games published by Nintendo, 0 for the others
+func (m *memory) titleChecksum() byte {
+	h := m.header
+	if h.OldLicensee != 0x01 && (h.OldLicensee != 0x33 || h.NewLicensee != "01") {
+		return 0
+	}
+	var sum byte
+	for address := uint16(0x134); address < 0x144; address++ {
+		sum += m.mbc.read(address)
+	}
+	return sum
+}
+
+// skipBoot sets the state left by the boot ROM of the model, to start
+// the game right away
+func (gb *gameBoy) skipBoot() {
+	m := gb.Memory
+	c := gb.CPU
+	c.PC, c.SP = 0x0100, 0xfffe
+	switch gb.Model {
+	case ModelDMG0:
+		c.A, c.F, c.B, c.C, c.D, c.E, c.H, c.L = 0x01, 0x00, 0xff, 0x13, 0x00, 0xc1, 0x84, 0x03
+	case ModelSGB, ModelSGB2:
+		c.A, c.F, c.B, c.C, c.D, c.E, c.H, c.L = 0x01, 0x00, 0x00, 0x14, 0x00, 0x00, 0xc0, 0x60
+	case ModelCGB, ModelAGB:
+		if m.header.CGB() {
+			c.A, c.F, c.B, c.C, c.D, c.E, c.H, c.L = 0x11, 0x80, 0x00, 0x00, 0xff, 0x56, 0x00, 0x0d
+		} else {
+			c.A, c.F, c.B, c.C, c.D, c.E, c.H, c.L = 0x11, 0x80, m.titleChecksum(), 0x00, 0x00, 0x08, 0x00, 0x7c
+		}
+	default:
+		c.A, c.F, c.B, c.C, c.D, c.E, c.H, c.L = 0x01, 0x80, 0x00, 0x13, 0x00, 0xd8, 0x01, 0x4d
+		if m.header.HeaderChecksum != 0 { // H and C come from the verification of the header
+			c.F |= 0x30
+		}
+	}
+	switch gb.Model {
+	case ModelMGB, ModelSGB2: // A is 0xff on the models with a newer CPU
+		c.A = 0xff
+	case ModelAGB: // the AGB boot ROM ends with an INC B
+		c.B++
+		c.setFlags(c.B == 0, false, c.B&0xf == 0, c.Cy())
+	}
+
+	gb.Timer.counter = 0xabcc
+	if gb.Model == ModelDMG0 {
+		gb.Timer.counter = 0x1830
+	}
+	gb.IRQ.flags = intVBlank
+	gb.Joypad.selected = 0x00 // P1 reads 0xcf, without sending an SGB reset pulse
+	gb.Joypad.update()
+
+	// The sound chip is left playing the end of the boot sound on channel 1
+	m.write(0xff26, 0x80)
+	for i, value := range []byte{
+		0x80, 0xbf, 0xf3, 0xff, 0xbf, 0xff, 0x3f, 0x00, 0xff, 0xbf, // NR10-NR24
+		0x7f, 0xff, 0x9f, 0xff, 0xbf, 0xff, 0xff, 0x00, 0x00, 0xbf, // NR30-NR44
+		0x77, 0xf3, // NR50-NR51
+	} {
+		m.write(0xff10+uint16(i), value)
+	}
+
+	m.write(0xff40, 0x91)
+	m.write(0xff47, 0xfc)
+	m.dma.register = 0xff
+	if gb.Model.cgb() {
+		p := gb.PPU
+		for i := range p.bgPalette.data { // the CGB games start with white palettes
+			p.bgPalette.data[i] = 0xff
+			p.objPalette.data[i] = 0xff
+		}
+		if !m.header.CGB() {
+			for i, color := range compatPalettes[0] {
+				p.bgPalette.data[i*2], p.bgPalette.data[i*2+1] = byte(color), byte(color>>8)
+			}
+			for i, color := range append(compatPalettes[1][:], compatPalettes[2][:]...) {
+				p.objPalette.data[i*2], p.objPalette.data[i*2+1] = byte(color), byte(color>>8)
+			}
+			m.setCompatibility()
+		}
+	}
+	m.boot = nil
+}
@@ -0,0 +1,86 @@
+package goboy
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBootROMUnmapping(t *testing.T) {
+	rom := make([]byte, 0x8000)
+	rom[0] = 42
+	rom[0x100] = 43
+	gb, err := NewGameBoy(&rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := gb.Memory
+	m.Assert(0, dmgBootROM[0], t)
+	m.Assert(0x100, 43, t)
+	m.Write(0xff50, 0)
+	m.Assert(0, dmgBootROM[0], t)
+	m.Write(0xff50, 1)
+	m.Assert(0, 42, t)
+	m.Assert(0xff50, 0xff, t)
+}
+
+// The CGB boot ROM leaves a window on the cartridge header
+func TestCGBBootROM(t *testing.T) {
+	rom := make([]byte, 0x8000)
+	rom[0x100] = 42
+	boot := make([]byte, cgbBootROMSize)
+	boot[0x100] = 1
+	boot[0x200] = 2
+	gb, err := NewGameBoy(&rom, WithModel(ModelCGB), WithBootROM(boot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gb.Memory.Assert(0x100, 42, t)
+	gb.Memory.Assert(0x200, 2, t)
+	gb.Memory.Assert(0x900, 0, t)
+
+	// The boot ROM has to match the model
+	if _, err := NewGameBoy(&rom, WithBootROM(boot)); !errors.Is(err, ErrInvalidBootROM) {
+		t.Error("Expected an invalid boot ROM error, got", err)
+	}
+	if _, err := NewGameBoy(&rom, WithModel(ModelCGB), WithBootROM(dmgBootROM[:])); !errors.Is(err, ErrInvalidBootROM) {
+		t.Error("Expected an invalid boot ROM error, got", err)
+	}
+}
+
+// Without boot ROM, the game starts in the state left by the boot ROM
+func TestSkipBoot(t *testing.T) {
+	tests := []struct {
+		name   string
+		flag   byte
+		af, hl uint16
+	}{
+		{"DMG", 0x00, 0x01b0, 0x014d},
+		{"SGB", 0x03, 0x0100, 0xc060},
+		{"CGB", 0x80, 0x1180, 0x000d},
+	}
+	for _, test := range tests {
+		rom := make([]byte, 0x8000)
+		rom[0x143] = test.flag
+		rom[0x146] = test.flag
+		rom[0x14b] = 0x33
+		rom[0x14d] = 1
+		gb, err := NewGameBoy(&rom, WithoutBootROM())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := gb.CPU
+		af, hl := uint16(c.A)<<8|uint16(c.F), uint16(c.H)<<8|uint16(c.L)
+		if af != test.af || hl != test.hl || c.PC != 0x100 || c.SP != 0xfffe {
+			t.Errorf("%s: expected AF=%#04x HL=%#04x, got AF=%#04x HL=%#04x PC=%#04x SP=%#04x",
+				test.name, test.af, test.hl, af, hl, c.PC, c.SP)
+		}
+		m := gb.Memory
+		m.Assert(0, 0, t)
+		m.Assert(0xff00, 0xcf, t)
+		m.Assert(0xff04, 0xab, t)
+		m.Assert(0xff0f, 0xe1, t)
+		m.Assert(0xff26, 0xf1, t)
+		m.Assert(0xff40, 0x91, t)
+		m.Assert(0xff47, 0xfc, t)
+	}
+}
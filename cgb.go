@@ -0,0 +1,45 @@
+package goboy
+
+// speedSwitchCycles is how long the CPU pauses while the speed changes
+const speedSwitchCycles = 2050
+
+// speed is the KEY1 register of the CGB. A switch between the normal and
+// the double speed is armed by writing it, then done by STOP.
+type speed struct {
+	double bool
+	armed  bool
+}
+
+// dots returns the number of dots of the PPU (or cycles of the APU) in
+// an M-cycle, they keep their frequency in double speed
+func (s *speed) dots() int {
+	if s != nil && s.double {
+		return 2
+	}
+	return 4
+}
+
+// toggle switches the speed when it is armed, it returns true if it did
+func (s *speed) toggle() bool {
+	if s == nil || !s.armed {
+		return false
+	}
+	s.armed = false
+	s.double = !s.double
+	return true
+}
+
+func (s *speed) read() byte {
+	value := byte(0x7e)
+	if s.double {
+		value |= 0x80
+	}
+	if s.armed {
+		value |= 0x01
+	}
+	return value
+}
+
+func (s *speed) write(value byte) {
+	s.armed = value&1 != 0
+}
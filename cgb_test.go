@@ -0,0 +1,122 @@
+package goboy
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func newTestCGBPPU() *ppu {
+	p := newTestPPU()
+	p.cgb = true
+	return p
+}
+
+func TestSpeedSwitch(t *testing.T) {
+	c := newTestCPU(0x3e, 0x01, 0xe0, 0x4d, 0x10, 0x00) // LD A, 1; LDH (KEY1), A; STOP
+	mem := c.mem.(*memory)
+	s := &speed{}
+	c.speed, mem.speed, mem.cgb = s, s, true
+	c.processOpcode()
+	c.processOpcode()
+	if key1 := mem.Read(0xff4d); key1 != 0x7f {
+		t.Errorf("Expected the switch to be armed, got KEY1=%#02x", key1)
+	}
+	if cycles := c.processOpcode(); cycles < speedSwitchCycles || c.stopped {
+		t.Error("Expected STOP to switch the speed without stopping, took", cycles)
+	}
+	if key1 := mem.Read(0xff4d); key1 != 0xfe || c.speed.dots() != 2 {
+		t.Errorf("Expected the double speed, got KEY1=%#02x", key1)
+	}
+}
+
+func TestWRAMBanks(t *testing.T) {
+	m := memory{cgb: true}
+	m.Write(0xd000, 1)
+	m.Write(0xff70, 2)
+	m.Write(0xd000, 2)
+	m.Assert(0xf000, 2, t) // the echo follows the bank
+	m.Assert(0xff70, 0xfa, t)
+	m.Write(0xff70, 0) // selects the bank 1
+	m.Assert(0xd000, 1, t)
+	m.Assert(0xf000, 1, t)
+
+	// SVBK is not mapped on DMG
+	m = memory{}
+	m.Write(0xff70, 2)
+	m.Write(0xd000, 3)
+	m.Assert(0xff70, 0xff, t)
+	if m.wram[0x1000] != 3 {
+		t.Error("Expected the bank 1 on DMG")
+	}
+}
+
+func TestPaletteRAM(t *testing.T) {
+	p := newTestCGBPPU()
+	p.write(0xff68, 0x80|0x3e) // auto-increment from the last color
+	p.write(0xff69, 0x1f)
+	p.write(0xff69, 0x7c)
+	p.write(0xff69, 0x55) // wraps to 0
+	if index := p.read(0xff68); index != 0xc1 {
+		t.Errorf("Expected the index to wrap to 1, got %#02x", index)
+	}
+	if color := p.bgPalette.color(7, 3); color != 0x7c1f {
+		t.Errorf("Expected color 0x7c1f, got %#04x", color)
+	}
+	if p.bgPalette.data[0] != 0x55 {
+		t.Error("Expected the third write at 0")
+	}
+
+	// The palettes are locked in mode 3
+	for p.mode != modeDrawing {
+		p.tickDot()
+	}
+	p.write(0xff6a, 0x80)
+	p.write(0xff6b, 0x42)
+	if p.objPalette.data[0] != 0 || p.read(0xff6b) != 0xff || p.read(0xff6a) != 0xc1 {
+		t.Error("Expected the write to be ignored and the index incremented")
+	}
+}
+
+func TestCGBTileAttributes(t *testing.T) {
+	p := newTestCGBPPU()
+	// Tile 1 of bank 1 has a single pixel of color 3 on its top left corner
+	p.vram[0x2010] = 0x80
+	p.vram[0x2011] = 0x80
+	p.vram[0x1800] = 1
+	p.vram[0x3800] = attrBank | attrXFlip | attrYFlip | 2
+	p.bgPalette.data[2*8+3*2] = 0x1f // palette 2, color 3 is red
+	p.runLines(ScreenHeight)
+
+	if p.front[7][7] != 0x001f || p.front[0][0] != 0 {
+		t.Error("Expected the red pixel at the bottom right of the tile")
+	}
+}
+
+// Both renderers draw the same frame in CGB mode too
+func TestCGBRenderersMatch(t *testing.T) {
+	scanline := newTestCGBPPU()
+	fifo := newTestCGBPPU()
+	fifo.fifo = &pixelFIFO{}
+
+	r := rand.New(rand.NewSource(42))
+	for _, p := range []*ppu{scanline, fifo} {
+		r.Seed(42)
+		r.Read(p.vram[:])
+		r.Read(p.bgPalette.data[:])
+		r.Read(p.objPalette.data[:])
+		for i := range p.oam {
+			p.oam[i] = byte(r.Intn(168))
+		}
+		p.lcdc |= lcdcWindowEnable
+		p.scx, p.scy, p.wx, p.wy = 13, 7, 60, 40
+		p.runLines(ScreenHeight)
+	}
+
+	for y := range scanline.front {
+		for x := range scanline.front[y] {
+			if scanline.front[y][x] != fifo.front[y][x] {
+				t.Fatalf("Renderers differ at (%d, %d)", x, y)
+			}
+		}
+	}
+}
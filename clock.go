@@ -0,0 +1,46 @@
+package goboy
+
+// device is a component driven by the master clock
+type device interface {
+	step() // advances the device by one M-cycle
+}
+
+// clock is the master clock shared by all the components of the console.
+// Every memory access from the CPU takes one M-cycle (4 clock cycles),
+// the devices are stepped right after so they stay in lockstep.
+type clock struct {
+	cycles   uint64 // M-cycles elapsed since power on
+	devices  []device
+	stallers []staller
+}
+
+// staller is a device that can stall the CPU, like the DMA of the CGB
+type staller interface {
+	device
+	stalled() bool
+}
+
+// attach registers a device to be stepped on every M-cycle
+func (c *clock) attach(d device) {
+	c.devices = append(c.devices, d)
+	if s, ok := d.(staller); ok {
+		c.stallers = append(c.stallers, s)
+	}
+}
+
+// stalled tells if a device stalls the CPU
+func (c *clock) stalled() bool {
+	for _, s := range c.stallers {
+		if s.stalled() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *clock) tick() {
+	c.cycles++
+	for _, d := range c.devices {
+		d.step()
+	}
+}
@@ -1,89 +1,206 @@
+// Command infos prints the header of ROMs and validates them.
+//
+// Usage: infos [--json] file-or-directory...
+//
+// The directories are walked for .gb, .gbc and .sgb files. The exit code
+// is 1 when a ROM can not be read or fails the validation.
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/geckovia/goboy"
 )
 
-func check(e error) {
-	if e != nil {
-		panic(e)
-	}
+// romExtensions are the files looked for in the directories
+var romExtensions = map[string]bool{".gb": true, ".gbc": true, ".sgb": true}
+
+// validation is the result of the checks of a ROM
+type validation struct {
+	Logo           bool `json:"logo"`
+	HeaderChecksum bool `json:"header_checksum"`
+	GlobalChecksum bool `json:"global_checksum"`
+	Size           bool `json:"size"` // the file size matches the declared ROM size
+}
+
+func (v validation) ok() bool {
+	return v.Logo && v.HeaderChecksum && v.GlobalChecksum && v.Size
 }
 
-func title(rom []byte) string {
-	return string(rom[0x134:0x143])
+// report is what is known about a ROM
+type report struct {
+	File          string      `json:"file"`
+	Error         string      `json:"error,omitempty"`
+	Title         string      `json:"title,omitempty"`
+	Manufacturer  string      `json:"manufacturer,omitempty"`
+	CGB           bool        `json:"cgb"`
+	CGBOnly       bool        `json:"cgb_only"`
+	SGB           bool        `json:"sgb"`
+	Licensee      string      `json:"licensee,omitempty"`
+	Japanese      bool        `json:"japanese"`
+	Version       byte        `json:"version"`
+	Cartridge     string      `json:"cartridge,omitempty"`
+	CartridgeCode byte        `json:"cartridge_code"`
+	ROMSize       int         `json:"rom_size"`
+	RAMSize       int         `json:"ram_size"`
+	FileSize      int         `json:"file_size"`
+	Validation    *validation `json:"validation,omitempty"`
 }
 
-func isColor(rom []byte) bool {
-	return rom[0x143] == 0x80
+func (r *report) ok() bool {
+	return r.Error == "" && r.Validation.ok()
 }
 
-func license(rom []byte) string {
-	license := rom[0x14b]
-	switch license {
-	case 0x79:
-		return "Accolade"
-	case 0xa4:
-		return "Konami"
-	case 0x33:
-		return string(rom[0x144:0x146])
-	default:
-		return string("Unknown")
+func inspect(path string) *report {
+	r := &report{File: path}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.FileSize = len(data)
+	header, err := goboy.ParseHeader(data)
+	if err != nil {
+		r.Error = fmt.Sprintf("%v: %d bytes, too short for a header", err, len(data))
+		return r
 	}
+	r.Title = header.Title
+	r.Manufacturer = header.Manufacturer
+	r.CGB = header.CGB()
+	r.CGBOnly = header.CGBOnly()
+	r.SGB = header.SGB()
+	r.Licensee = header.Licensee()
+	r.Japanese = header.Japanese()
+	r.Version = header.Version
+	r.Cartridge = header.Cartridge.String()
+	r.CartridgeCode = byte(header.Cartridge)
+	r.ROMSize = header.ROMSize()
+	r.RAMSize = header.RAMSize()
+	r.Validation = &validation{
+		Logo:           header.LogoValid,
+		HeaderChecksum: header.HeaderChecksumValid,
+		GlobalChecksum: header.GlobalChecksumValid,
+		Size:           r.ROMSize == len(data),
+	}
+	return r
 }
 
-func destination(rom []byte) string {
-	if rom[0x14a] != 0 {
-		return "Japanese"
+// files lists the ROMs to inspect, the directories are walked
+func files(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil || !info.IsDir() {
+			paths = append(paths, arg) // the error is reported with the file
+			continue
+		}
+		err = filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && romExtensions[strings.ToLower(filepath.Ext(path))] {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
-	return "Non-Japanese"
+	return paths, nil
 }
 
-func cartridgeType(rom []byte) string {
-	switch rom[0x147] {
-	case 0:
-		return "ROM ONLY"
-	case 1:
-		return "ROM+MBC1"
-	case 0x13:
-		return "ROM+MBC3+RAM+BATT"
-	default:
-		return string(rom[0x147])
+func check(ok bool) string {
+	if ok {
+		return "OK"
 	}
+	return "FAILED"
 }
 
-func romSize(rom []byte) int {
-	value := int(rom[0x148])
-	size := 32 * 1024
-	for i := 0; i < value; i++ {
-		size *= 2
+func yesNo(b bool) string {
+	if b {
+		return "yes"
 	}
-	return size
+	return "no"
 }
 
-func ramSize(rom []byte) int {
-	value := int(rom[0x149])
-	if value == 0 {
-		return 0
+func printReport(r *report) {
+	fmt.Println(r.File)
+	fmt.Println(strings.Repeat("-", len(r.File)))
+	if r.Error != "" {
+		fmt.Println("Error:\t\t", r.Error)
+		return
+	}
+	destination := "Non-Japanese"
+	if r.Japanese {
+		destination = "Japanese"
 	}
-	size := 2048
-	for i := 0; i < value-1; i++ {
-		size *= 4
+	fmt.Println("Title:\t\t \"" + r.Title + "\"")
+	if r.Manufacturer != "" {
+		fmt.Println("Manufacturer:\t", r.Manufacturer)
 	}
-	return size
+	fmt.Println("GameBoy Color:\t", yesNo(r.CGB))
+	fmt.Println("Super GameBoy:\t", yesNo(r.SGB))
+	fmt.Println("License:\t", r.Licensee)
+	fmt.Println("Destination:\t", destination)
+	fmt.Println("Version:\t", r.Version)
+	fmt.Printf("Cartridge type:\t %v (%#02x)\n", r.Cartridge, r.CartridgeCode)
+	fmt.Println("ROM size:\t", r.ROMSize/1024, "kB")
+	fmt.Println("RAM size:\t", r.RAMSize/1024, "kB")
+	fmt.Println("Validation")
+	fmt.Println("  Nintendo logo:\t", check(r.Validation.Logo))
+	fmt.Println("  Header checksum:\t", check(r.Validation.HeaderChecksum))
+	fmt.Println("  Global checksum:\t", check(r.Validation.GlobalChecksum))
+	fmt.Printf("  Size:\t\t\t %s (%d bytes, %d declared)\n", check(r.Validation.Size), r.FileSize, r.ROMSize)
 }
 
 func main() {
-	dat, err := ioutil.ReadFile(os.Args[1])
-	check(err)
-	fmt.Println("ROM information\n---------------")
-	fmt.Println("Title:\t\"" + title(dat) + "\"")
-	fmt.Println("GameBoy Color:\t", isColor(dat))
-	fmt.Println("License:\t", license(dat))
-	fmt.Println("Destination:\t", destination(dat))
-	fmt.Println("Cartridge type:\t", cartridgeType(dat))
-	fmt.Println("ROM size:\t", romSize(dat)/1024, "kB")
-	fmt.Println("RAM size:\t", ramSize(dat)/1024, "kB")
+	jsonOutput := flag.Bool("json", false, "print the reports as a JSON array")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: infos [--json] file-or-directory...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	paths, err := files(flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	failed := false
+	reports := make([]*report, 0, len(paths))
+	for i, path := range paths {
+		r := inspect(path)
+		failed = failed || !r.ok()
+		if *jsonOutput {
+			reports = append(reports, r)
+			continue
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		printReport(r)
+	}
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(reports); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
 }
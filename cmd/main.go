@@ -1,18 +1,71 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/geckovia/goboy"
 )
 
 func main() {
-	data, err := ioutil.ReadFile(os.Args[1])
+	listen := flag.String("listen", "", "wait for another Game Boy on this TCP address, for the link cable")
+	connect := flag.String("connect", "", "connect the link cable to a Game Boy waiting on this TCP address")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: goboy [-listen address | -connect address] rom")
+		os.Exit(2)
+	}
+	path := flag.Arg(0)
+
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
 		panic(err)
 	}
 
-	gb := goboy.NewGameBoy(&data)
-	gb.Run()
+	options := []goboy.Option{goboy.WithAtomicSaveFile(goboy.SavePath(path))}
+	var link *goboy.Link
+	switch {
+	case *listen != "":
+		link, err = goboy.ListenLink(*listen)
+	case *connect != "":
+		link, err = goboy.DialLink(*connect)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if link != nil {
+		defer link.Close()
+		options = append(options, goboy.WithLink(link))
+	}
+
+	emulator, err := goboy.NewEmulator(data, options...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// The save is written on exit
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	for {
+		select {
+		case <-stop:
+			if err := emulator.Flush(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		default:
+		}
+		if err := emulator.StepFrame(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			emulator.Flush()
+			os.Exit(1)
+		}
+	}
 }
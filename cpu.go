@@ -1,28 +1,45 @@
 package goboy
 
-import (
-	"strconv"
-)
+// bus is the address space as seen by the CPU
+type bus interface {
+	Read(address uint16) byte
+	Write(address uint16, value byte)
+}
 
 // cpu emulates the DMG micro-controller
 type cpu struct {
-	mem   *memory // A pointer to the memory system
-	A     byte
-	B     byte
-	C     byte
-	D     byte
-	E     byte
-	F     byte // flags
-	H     byte
-	L     byte
-	SP    uint16 // stack pointer
-	PC    uint16 // program counter
-	start bool
-}
-
-// Emulates a CPU clock cycle
+	mem     bus         // The memory system
+	clock   *clock      // The master clock
+	irq     *interrupts // The interrupt controller
+	onStop  func()      // Lets the other components react to STOP
+	speed   *speed      // KEY1, in CGB mode
+	A       byte
+	B       byte
+	C       byte
+	D       byte
+	E       byte
+	F       byte // flags
+	H       byte
+	L       byte
+	SP      uint16 // stack pointer
+	PC      uint16 // program counter
+	start   bool
+	ime     bool // interrupt master enable
+	eiDelay bool // EI enables the interrupts after the next instruction
+	halted  bool
+	haltBug bool // the next fetch does not increment PC
+	stopped bool
+	locked  bool   // set by illegal opcodes, only a reset can recover
+	fault   *Fault // why the CPU is locked
+}
+
+// Emulates a CPU machine cycle, the cycles during which
+// the CPU is stalled by a DMA go by too
 func (c *cpu) tick() {
-	// TODO when a clock will be implemented
+	c.clock.tick()
+	for c.clock.stalled() {
+		c.clock.tick()
+	}
 }
 
 // HL register
@@ -115,8 +132,8 @@ func (c *cpu) load8PC() byte {
 }
 
 func (c *cpu) load16(address uint16) uint16 {
-	high := c.load8(address + 1)
 	low := c.load8(address)
+	high := c.load8(address + 1)
 	return uint16(high)<<8 + uint16(low)
 }
 
@@ -142,9 +159,11 @@ func (c *cpu) jump(address uint16) {
 }
 
 func (c *cpu) push(value uint16) {
-	c.SP -= 2
-	c.write16(c.SP, value)
-	c.tick() // I don't understand why pushes takes 4 cycles more than a pop...
+	c.tick() // the SP is decremented before the first write
+	c.SP--
+	c.write8(c.SP, byte(value>>8))
+	c.SP--
+	c.write8(c.SP, byte(value))
 }
 
 func (c *cpu) pop() uint16 {
@@ -155,7 +174,7 @@ func (c *cpu) pop() uint16 {
 
 func (c *cpu) call(address uint16) {
 	c.push(c.PC)
-	c.PC = address // call is 24 cycles, so by using a push we can't also use a jump
+	c.PC = address // the push already accounts for the internal cycle of the call
 }
 
 func (c *cpu) ret() {
@@ -230,10 +249,15 @@ func add(c *cpu, value int) int {
 }
 
 func addc(c *cpu, value int) int {
+	carry := 0
 	if c.Cy() {
-		value++
+		carry = 1
 	}
-	return add(c, value)
+	a := int(c.A)
+	sum := a + value + carry
+	halfCarry := (a&0xf)+(value&0xf)+carry > 0xf
+	c.setFlags(byte(sum) == 0, false, halfCarry, sum > 0xff)
+	return sum
 }
 
 func sub(c *cpu, value int) int {
@@ -246,10 +270,15 @@ func sub(c *cpu, value int) int {
 }
 
 func sbc(c *cpu, value int) int {
+	carry := 0
 	if c.Cy() {
-		value++
+		carry = 1
 	}
-	return sub(c, value)
+	a := int(c.A)
+	diff := a - value - carry
+	halfCarry := (a&0xf)-(value&0xf)-carry < 0
+	c.setFlags(byte(diff) == 0, true, halfCarry, diff < 0)
+	return diff
 }
 
 func and(c *cpu, value int) int {
@@ -266,19 +295,19 @@ func or(c *cpu, value int) int {
 
 func xor(c *cpu, value int) int {
 	result := c.A ^ byte(value)
-	c.setFlags(c.A == 0, false, false, false)
+	c.setFlags(result == 0, false, false, false)
 	return int(result)
 }
 
 func cp(c *cpu, value int) int {
 	sub(c, value)
-	return value
+	return int(c.A) // only the flags are affected
 }
 
 func bit(n uint) registerOperation {
 	return func(c *cpu, value int) int {
 		present := (value & (1 << n)) != 0
-		c.setFlags(present, false, true, c.Cy())
+		c.setFlags(!present, false, true, c.Cy())
 		return value
 	}
 }
@@ -355,36 +384,155 @@ func srl(c *cpu, value int) int {
 
 func swap(c *cpu, value int) int {
 	v := byte(value)
-	result := (v&0xf0)<<4 + (v&0x0f)>>4
+	result := v<<4 | v>>4
 	c.setFlags(result == 0, false, false, false)
 	return int(result)
 }
 
 func inc(c *cpu, value int) int {
 	v := value + 1
-	halfCarry := (v&0xf)+(value&0xf) > 0xf
+	halfCarry := (value&0xf)+1 > 0xf
 	c.setFlags(byte(v) == 0, false, halfCarry, c.Cy())
 	return v
 }
 
 func dec(c *cpu, value int) int {
 	v := value - 1
-	halfCarry := (v&0xf)-(value&0xf) < 0
+	halfCarry := (value&0xf)-1 < 0
 	c.setFlags(byte(v) == 0, true, halfCarry, c.Cy())
 	return v
 }
 
+// addHL adds a 16 bits value to HL, the Z flag is not affected
+func (c *cpu) addHL(value uint16) {
+	hl := c.HL()
+	sum := uint32(hl) + uint32(value)
+	halfCarry := (hl&0xfff)+(value&0xfff) > 0xfff
+	c.setFlags(c.Z(), false, halfCarry, sum > 0xffff)
+	c.setHL(uint16(sum))
+	c.tick()
+}
+
+// addSP computes SP plus a signed offset read after the opcode.
+// Flags are computed on the low byte as for an unsigned addition.
+func (c *cpu) addSP() uint16 {
+	offset := c.load8PC()
+	sp := c.SP
+	halfCarry := (sp&0xf)+uint16(offset&0xf) > 0xf
+	carry := (sp&0xff)+uint16(offset) > 0xff
+	c.setFlags(false, false, halfCarry, carry)
+	return sp + uint16(int8(offset))
+}
+
+// daa adjusts A to a valid BCD number after an addition or a subtraction
+func (c *cpu) daa() {
+	a := c.A
+	carry := c.Cy()
+	if !c.N() {
+		if carry || a > 0x99 {
+			a += 0x60
+			carry = true
+		}
+		if c.Hy() || a&0xf > 0x9 {
+			a += 0x6
+		}
+	} else {
+		if carry {
+			a -= 0x60
+		}
+		if c.Hy() {
+			a -= 0x6
+		}
+	}
+	c.setFlags(a == 0, c.N(), false, carry)
+	c.A = a
+}
+
 var arithmeticOps = []registerOperation{add, addc, sub, sbc, and, xor, or, cp}
 var cbOps = []registerOperation{rlc, rrc, rl, rr, sla, sra, swap, srl}
 
-// processCode emulates the fetching and processing
-// of an instruction by the CPU
-func (c *cpu) processOpcode() {
+// processOpcode emulates the fetching and processing of an instruction
+// by the CPU, or the servicing of an interrupt.
+// It returns the number of M-cycles it took.
+func (c *cpu) processOpcode() int {
+	start := c.clock.cycles
+	c.step()
+	return int(c.clock.cycles - start)
+}
+
+func (c *cpu) step() {
+	switch {
+	case c.locked: // A locked CPU never fetches again, but time still goes by
+		c.tick()
+		return
+	case c.stopped: // Only a joypad input can get out of STOP
+		c.tick()
+		if c.irq.flags&intJoypad == 0 {
+			return
+		}
+		c.stopped = false
+	case c.halted: // Any pending interrupt wakes the CPU, even when IME is reset
+		c.tick()
+		if c.irq.pending() == 0 {
+			return
+		}
+		c.halted = false
+	}
+
+	if c.ime && c.irq.pending() != 0 {
+		c.dispatch()
+		return
+	}
+	if c.eiDelay {
+		c.eiDelay = false
+		c.ime = true
+	}
+	c.execute()
+}
+
+// dispatch services the pending interrupt of highest priority
+func (c *cpu) dispatch() {
+	c.ime = false
+	c.tick()
+	c.tick()
+	c.SP--
+	c.write8(c.SP, byte(c.PC>>8))
+	// Pushing the high byte of PC can overwrite IE and cancel the interrupt,
+	// in that case the CPU jumps to 0x0000.
+	pending := c.irq.pending()
+	c.SP--
+	c.write8(c.SP, byte(c.PC))
+	c.PC = 0
+	for n := uint(0); n < 5; n++ {
+		if pending&(1<<n) != 0 {
+			c.irq.flags &^= 1 << n
+			c.PC = 0x40 + uint16(n)*8
+			break
+		}
+	}
+	c.tick()
+}
+
+// halt stops the CPU until an interrupt is pending
+func (c *cpu) halt() {
+	if !c.ime && c.irq.pending() != 0 {
+		// HALT bug: the CPU does not halt and reads the next byte twice
+		c.haltBug = true
+		return
+	}
+	c.halted = true
+}
+
+func (c *cpu) execute() {
 	opcode := c.load8PC()
+	if c.haltBug {
+		c.haltBug = false
+		c.PC--
+	}
 
 	// HALT (must be done before the LD group)
 	if opcode == 0x76 {
-		// TODO
+		c.halt()
 		return
 	}
 
@@ -397,13 +545,26 @@ func (c *cpu) processOpcode() {
 	}
 
 	// Common arithmetic
-	if 0x80 <= opcode && opcode <= 0xc0 {
+	if 0x80 <= opcode && opcode < 0xc0 {
 		op := int((opcode - 0x80) >> 3)
 		src := int(opcode & 7)
 		c.applyOp(7, src, arithmeticOps[op])
 		return
 	}
 
+	// Arithmetic with an immediate value
+	if 0xc0 <= opcode && opcode&7 == 6 {
+		op := int((opcode - 0xc0) >> 3)
+		c.A = byte(arithmeticOps[op](c, int(c.load8PC())))
+		return
+	}
+
+	// RST
+	if 0xc0 <= opcode && opcode&7 == 7 {
+		c.call(uint16(opcode - 0xc7))
+		return
+	}
+
 	// Other opcodes
 	switch opcode {
 	case 0x00: // NOP
@@ -414,6 +575,7 @@ func (c *cpu) processOpcode() {
 		c.write8(c.BC(), c.A)
 	case 0x03: // INC BC
 		c.setBC(c.BC() + 1)
+		c.tick()
 	case 0x04: // INC B
 		c.applyOp(0, 0, inc)
 	case 0x05: // DEC B
@@ -422,10 +584,16 @@ func (c *cpu) processOpcode() {
 		c.B = c.load8PC()
 	case 0x07: // RLCA
 		c.applyOp(7, 7, rlc)
+		c.F &^= 0x80 // unlike RLC A, Z is always reset
+	case 0x08: // LD (nn), SP
+		c.write16(c.load16PC(), c.SP)
+	case 0x09: // ADD HL, BC
+		c.addHL(c.BC())
 	case 0x0a: // LD A, (BC)
 		c.A = c.load8(c.BC())
 	case 0x0b: // DEC BC
 		c.setBC(c.BC() - 1)
+		c.tick()
 	case 0x0c: // INC C
 		c.applyOp(1, 1, inc)
 	case 0x0d: // DEC C
@@ -434,12 +602,27 @@ func (c *cpu) processOpcode() {
 		c.C = c.load8PC()
 	case 0x0f: // RRCA
 		c.applyOp(7, 7, rrc)
+		c.F &^= 0x80
+	case 0x10: // STOP
+		c.load8PC() // STOP is followed by a padding byte
+		if c.onStop != nil {
+			c.onStop()
+		}
+		// The CGB switches its speed instead of stopping when it is armed
+		if c.speed.toggle() {
+			for i := 0; i < speedSwitchCycles; i++ {
+				c.tick()
+			}
+		} else {
+			c.stopped = true
+		}
 	case 0x11: // LD DE, nn
 		c.setDE(c.load16PC())
 	case 0x12: // LD (DE), A
 		c.write8(c.DE(), c.A)
 	case 0x13: // INC DE
 		c.setDE(c.DE() + 1)
+		c.tick()
 	case 0x14: // INC D
 		c.applyOp(2, 2, inc)
 	case 0x15: // DEC D
@@ -448,13 +631,26 @@ func (c *cpu) processOpcode() {
 		c.D = c.load8PC()
 	case 0x17: // RLA
 		c.applyOp(7, 7, rl)
+		c.F &^= 0x80
 	case 0x18: // JR, r8
 		address := c.PC + uint16(int8(c.load8PC()))
 		c.jump(address)
+	case 0x19: // ADD HL, DE
+		c.addHL(c.DE())
 	case 0x1a: // LD A, (DE)
 		c.A = c.load8(c.DE())
+	case 0x1b: // DEC DE
+		c.setDE(c.DE() - 1)
+		c.tick()
+	case 0x1c: // INC E
+		c.applyOp(3, 3, inc)
+	case 0x1d: // DEC E
+		c.applyOp(3, 3, dec)
 	case 0x1e: // LD E, n
 		c.E = c.load8PC()
+	case 0x1f: // RRA
+		c.applyOp(7, 7, rr)
+		c.F &^= 0x80
 	case 0x20: // JR NZ, r8
 		address := c.PC + uint16(int8(c.load8PC()))
 		if !c.Z() {
@@ -468,19 +664,38 @@ func (c *cpu) processOpcode() {
 		c.setHL(hl + 1)
 	case 0x23: // INC HL
 		c.setHL(c.HL() + 1)
+		c.tick()
+	case 0x24: // INC H
+		c.applyOp(4, 4, inc)
+	case 0x25: // DEC H
+		c.applyOp(4, 4, dec)
 	case 0x26: // LD H, n
 		c.H = c.load8PC()
+	case 0x27: // DAA
+		c.daa()
 	case 0x28: // JR Z, r8
 		address := c.PC + uint16(int8(c.load8PC()))
 		if c.Z() {
 			c.jump(address)
 		}
+	case 0x29: // ADD HL, HL
+		c.addHL(c.HL())
 	case 0x2a: // LD A, (HL+)
 		hl := c.HL()
 		c.A = c.load8(hl)
 		c.setHL(hl + 1)
+	case 0x2b: // DEC HL
+		c.setHL(c.HL() - 1)
+		c.tick()
+	case 0x2c: // INC L
+		c.applyOp(5, 5, inc)
+	case 0x2d: // DEC L
+		c.applyOp(5, 5, dec)
 	case 0x2e: // LD L, n
 		c.L = c.load8PC()
+	case 0x2f: // CPL
+		c.A = ^c.A
+		c.setFlags(c.Z(), true, true, c.Cy())
 	case 0x30: // JR NC, r8
 		address := c.PC + uint16(int8(c.load8PC()))
 		if !c.Cy() {
@@ -492,36 +707,119 @@ func (c *cpu) processOpcode() {
 		hl := c.HL()
 		c.write8(hl, c.A)
 		c.setHL(hl - 1)
+	case 0x33: // INC SP
+		c.SP++
+		c.tick()
+	case 0x34: // INC (HL)
+		c.applyOp(6, 6, inc)
+	case 0x35: // DEC (HL)
+		c.applyOp(6, 6, dec)
 	case 0x36: // LD (HL), n
 		c.write8(c.HL(), c.load8PC())
+	case 0x37: // SCF
+		c.setFlags(c.Z(), false, false, true)
 	case 0x38: // JR C, r8
 		address := c.PC + uint16(int8(c.load8PC()))
 		if c.Cy() {
 			c.jump(address)
 		}
+	case 0x39: // ADD HL, SP
+		c.addHL(c.SP)
 	case 0x3a: // LD A, (HL-)
 		hl := c.HL()
 		c.A = c.load8(hl)
 		c.setHL(hl - 1)
+	case 0x3b: // DEC SP
+		c.SP--
+		c.tick()
+	case 0x3c: // INC A
+		c.applyOp(7, 7, inc)
 	case 0x3d: // DEC A
 		c.applyOp(7, 7, dec)
 	case 0x3e: // LD A, n
 		c.A = c.load8PC()
+	case 0x3f: // CCF
+		c.setFlags(c.Z(), false, false, !c.Cy())
+	case 0xc0: // RET NZ
+		c.tick()
+		if !c.Z() {
+			c.ret()
+		}
 	case 0xc1: // POP BC
 		c.setBC(c.pop())
+	case 0xc2: // JP NZ, nn
+		address := c.load16PC()
+		if !c.Z() {
+			c.jump(address)
+		}
+	case 0xc3: // JP nn
+		c.jump(c.load16PC())
+	case 0xc4: // CALL NZ, nn
+		destination := c.load16PC()
+		if !c.Z() {
+			c.call(destination)
+		}
 	case 0xc5: // PUSH BC
 		c.push(c.BC())
+	case 0xc8: // RET Z
+		c.tick()
+		if c.Z() {
+			c.ret()
+		}
 	case 0xc9: // RET
 		c.ret()
+	case 0xca: // JP Z, nn
+		address := c.load16PC()
+		if c.Z() {
+			c.jump(address)
+		}
 	case 0xcb: // CB prefix
 		c.cb()
+	case 0xcc: // CALL Z, nn
+		destination := c.load16PC()
+		if c.Z() {
+			c.call(destination)
+		}
 	case 0xcd: // CALL nn
 		destination := c.load16PC()
 		c.call(destination)
+	case 0xd0: // RET NC
+		c.tick()
+		if !c.Cy() {
+			c.ret()
+		}
 	case 0xd1: // POP DE
 		c.setDE(c.pop())
+	case 0xd2: // JP NC, nn
+		address := c.load16PC()
+		if !c.Cy() {
+			c.jump(address)
+		}
+	case 0xd4: // CALL NC, nn
+		destination := c.load16PC()
+		if !c.Cy() {
+			c.call(destination)
+		}
 	case 0xd5: // PUSH DE
 		c.push(c.DE())
+	case 0xd8: // RET C
+		c.tick()
+		if c.Cy() {
+			c.ret()
+		}
+	case 0xd9: // RETI
+		c.ret()
+		c.ime = true
+	case 0xda: // JP C, nn
+		address := c.load16PC()
+		if c.Cy() {
+			c.jump(address)
+		}
+	case 0xdc: // CALL C, nn
+		destination := c.load16PC()
+		if c.Cy() {
+			c.call(destination)
+		}
 	case 0xe0: // LDH n A
 		c.write8(0xff00+uint16(c.load8PC()), c.A)
 	case 0xe1: // POP HL
@@ -530,47 +828,60 @@ func (c *cpu) processOpcode() {
 		c.write8(0xff00+uint16(c.C), c.A)
 	case 0xe5: // PUSH HL
 		c.push(c.HL())
+	case 0xe8: // ADD SP, r8
+		c.SP = c.addSP()
+		c.tick()
+		c.tick()
+	case 0xe9: // JP HL
+		c.PC = c.HL()
 	case 0xea: // LD (nn) A
 		c.write8(c.load16PC(), c.A)
 	case 0xf0: // LDH A n
 		c.A = c.load8(0xff00 + uint16(c.load8PC()))
 	case 0xf1: // POP AF
-		c.setAF(c.pop())
+		c.setAF(c.pop() & 0xfff0) // the low nibble of F is always zero
 	case 0xf2: // LD A (C)
 		c.A = c.load8(0xff00 + uint16(c.C))
+	case 0xf3: // DI
+		c.ime = false
+		c.eiDelay = false
 	case 0xf5: // PUSH AF
 		c.push(c.AF())
-	case 0xfe: // CP n
-		cp(c, int(c.load8PC()))
+	case 0xf8: // LD HL, SP+r8
+		c.setHL(c.addSP())
+		c.tick()
+	case 0xf9: // LD SP, HL
+		c.SP = c.HL()
+		c.tick()
+	case 0xfa: // LD A (nn)
+		c.A = c.load8(c.load16PC())
+	case 0xfb: // EI
+		c.eiDelay = !c.ime
 	default:
-		panic("Unknown opcode 0x" + strconv.FormatInt(int64(opcode), 16))
+		// 0xd3, 0xdb, 0xdd, 0xe3, 0xe4, 0xeb, 0xec, 0xed, 0xf4, 0xfc and 0xfd
+		// are not mapped and hang the CPU
+		c.locked = true
+		c.fault = &Fault{ErrIllegalOpcode, c.PC - 1, opcode}
 	}
 }
 
-//var cbOps = []registerOperation{}
-
 // CB-prefixed opcodes
 func (c *cpu) cb() {
 	opcode := c.load8PC()
+	reg := int(opcode & 7)
 
 	switch {
 	case opcode < 0x40:
 		op := uint(opcode >> 3)
-		reg := int(opcode & 7)
 		c.applyOp(reg, reg, cbOps[op])
 	case 0x40 <= opcode && opcode < 0x80:
 		n := uint((opcode - 0x40) >> 3)
-		reg := int(opcode & 7)
-		c.applyOp(reg, reg, bit(n))
+		bit(n)(c, int(c.getReg(reg))) // BIT only reads its operand
 	case 0x80 <= opcode && opcode < 0xc0:
 		n := uint((opcode - 0x80) >> 3)
-		reg := int(opcode & 7)
 		c.applyOp(reg, reg, res(n))
-	case 0xc0 <= opcode && opcode < 0xf0:
+	default:
 		n := uint((opcode - 0xc0) >> 3)
-		reg := int(opcode & 7)
 		c.applyOp(reg, reg, set(n))
-	default:
-		panic("Unknown opcode 0xbc 0x" + strconv.FormatInt(int64(opcode), 16))
 	}
 }
@@ -1,17 +1,120 @@
 package goboy
 
 import (
+	"errors"
 	"testing"
 )
 
 func TestBoot(t *testing.T) {
-	mem := memory{}
 	data := make([]byte, 0x4000)
+	gb, err := NewGameBoy(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for gb.CPU.PC != 0xe0 {
+		gb.CPU.processOpcode()
+	}
+}
+
+// newTestCPU returns a CPU executing the given program from the internal RAM
+func newTestCPU(program ...byte) *cpu {
+	irq := interrupts{}
+	mem := memory{irq: &irq}
+	data := make([]byte, 0x8000)
 	mem.loadRom(&data)
-	processor := cpu{}
-	processor.mem = &mem
+	for i, b := range program {
+		mem.Write(0xc000+uint16(i), b)
+	}
+	return &cpu{mem: &mem, clock: &clock{}, irq: &irq, PC: 0xc000, SP: 0xdffe}
+}
+
+func TestDAA(t *testing.T) {
+	c := newTestCPU(0x3e, 0x15, 0xc6, 0x27, 0x27) // LD A, 0x15; ADD 0x27; DAA
+	for i := 0; i < 3; i++ {
+		c.processOpcode()
+	}
+	if c.A != 0x42 || c.F != 0 {
+		t.Errorf("Expected A=0x42 F=0x00, got A=%#02x F=%#02x", c.A, c.F)
+	}
+}
+
+func TestCallAndRet(t *testing.T) {
+	c := newTestCPU(0xcd, 0x10, 0xc0) // CALL 0xc010
+	c.mem.Write(0xc010, 0xc9)         // RET
+	if cycles := c.processOpcode(); cycles != 6 {
+		t.Error("Expected CALL to take 6 cycles, got", cycles)
+	}
+	if c.PC != 0xc010 || c.SP != 0xdffc {
+		t.Errorf("Expected PC=0xc010 SP=0xdffc, got PC=%#04x SP=%#04x", c.PC, c.SP)
+	}
+	mem := c.mem.(*memory)
+	mem.Assert(0xdffd, 0xc0, t)
+	mem.Assert(0xdffc, 0x03, t)
+	if cycles := c.processOpcode(); cycles != 4 {
+		t.Error("Expected RET to take 4 cycles, got", cycles)
+	}
+	if c.PC != 0xc003 || c.SP != 0xdffe {
+		t.Errorf("Expected PC=0xc003 SP=0xdffe, got PC=%#04x SP=%#04x", c.PC, c.SP)
+	}
+}
+
+func TestIllegalOpcodeLocks(t *testing.T) {
+	c := newTestCPU(0xd3, 0x3c) // illegal; INC A
+	c.processOpcode()
+	c.processOpcode()
+	if !c.locked || c.A != 0 || c.PC != 0xc001 {
+		t.Errorf("Expected a locked CPU at 0xc001, got locked=%v PC=%#04x", c.locked, c.PC)
+	}
+	if !errors.Is(c.fault, ErrIllegalOpcode) || c.fault.PC != 0xc000 || c.fault.Opcode != 0xd3 {
+		t.Error("Expected an illegal opcode fault, got", c.fault)
+	}
+}
+
+func TestInterruptDispatch(t *testing.T) {
+	c := newTestCPU(0xfb, 0x00, 0x00) // EI; NOP; NOP
+	c.irq.enable = intTimer | intSerial
+	c.irq.request(intSerial)
+	c.irq.request(intTimer)
+	c.processOpcode()
+	c.processOpcode() // the instruction following EI is always executed
+	if c.PC != 0xc002 {
+		t.Fatalf("Expected PC=0xc002, got %#04x", c.PC)
+	}
+	if cycles := c.processOpcode(); cycles != 5 {
+		t.Error("Expected the dispatch to take 5 cycles, got", cycles)
+	}
+	if c.PC != 0x50 || c.ime || c.irq.flags != intSerial {
+		t.Errorf("Expected the timer interrupt, got PC=%#04x IME=%v IF=%#02x", c.PC, c.ime, c.irq.flags)
+	}
+	if c.SP != 0xdffc || c.pop() != 0xc002 {
+		t.Error("Expected the return address to be pushed")
+	}
+}
+
+func TestHaltWakeUp(t *testing.T) {
+	c := newTestCPU(0x76, 0x3c) // HALT; INC A
+	c.irq.enable = intVBlank
+	c.processOpcode()
+	c.processOpcode()
+	if !c.halted || c.PC != 0xc001 {
+		t.Fatal("Expected the CPU to be halted")
+	}
+	c.irq.request(intVBlank) // wakes the CPU without servicing the interrupt
+	c.processOpcode()
+	if c.halted || c.A != 1 {
+		t.Errorf("Expected the CPU to resume after HALT, got A=%d", c.A)
+	}
+}
 
-	for processor.PC != 0xe0 {
-		processor.processOpcode()
+func TestHaltBug(t *testing.T) {
+	c := newTestCPU(0x76, 0x3c, 0x00) // HALT; INC A; NOP
+	c.irq.enable = intVBlank
+	c.irq.request(intVBlank)
+	c.processOpcode()
+	c.processOpcode()
+	c.processOpcode()
+	if c.halted || c.A != 2 || c.PC != 0xc002 {
+		t.Errorf("Expected INC A to be executed twice, got A=%d PC=%#04x", c.A, c.PC)
 	}
 }
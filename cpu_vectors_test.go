@@ -0,0 +1,208 @@
+package goboy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The single-step vectors follow the format of the SM83 tests
+// (https://github.com/SingleStepTests/sm83): one JSON file per opcode,
+// each one holding a list of vectors. Drop the whole suite in
+// testdata/sm83 or point SM83_TESTS to another directory.
+const defaultVectorsDir = "testdata/sm83"
+
+type vectorState struct {
+	A   byte        `json:"a"`
+	B   byte        `json:"b"`
+	C   byte        `json:"c"`
+	D   byte        `json:"d"`
+	E   byte        `json:"e"`
+	F   byte        `json:"f"`
+	H   byte        `json:"h"`
+	L   byte        `json:"l"`
+	PC  uint16      `json:"pc"`
+	SP  uint16      `json:"sp"`
+	IME byte        `json:"ime"`
+	IE  byte        `json:"ie"`
+	RAM [][2]uint16 `json:"ram"`
+}
+
+type vector struct {
+	Name    string          `json:"name"`
+	Initial vectorState     `json:"initial"`
+	Final   vectorState     `json:"final"`
+	Cycles  [][]interface{} `json:"cycles"`
+}
+
+// busCycle is what the bus did during an M-cycle
+type busCycle struct {
+	address  uint16
+	value    byte
+	write    bool
+	internal bool // no memory access
+}
+
+func (b busCycle) String() string {
+	if b.internal {
+		return "internal cycle"
+	}
+	if b.write {
+		return fmt.Sprintf("write %#04x=%#02x", b.address, b.value)
+	}
+	return fmt.Sprintf("read %#04x=%#02x", b.address, b.value)
+}
+
+// flatMemory is a 64kB RAM recording every bus cycle.
+// It is attached to the clock to notice the internal cycles.
+type flatMemory struct {
+	ram      [0x10000]byte
+	cycles   []busCycle
+	accessed bool // an access happened during the current cycle
+}
+
+func (m *flatMemory) Read(address uint16) byte {
+	value := m.ram[address]
+	m.cycles = append(m.cycles, busCycle{address: address, value: value})
+	m.accessed = true
+	return value
+}
+
+func (m *flatMemory) Write(address uint16, value byte) {
+	m.ram[address] = value
+	m.cycles = append(m.cycles, busCycle{address: address, value: value, write: true})
+	m.accessed = true
+}
+
+func (m *flatMemory) step() {
+	if !m.accessed {
+		m.cycles = append(m.cycles, busCycle{internal: true})
+	}
+	m.accessed = false
+}
+
+// expectedCycles returns the bus cycles of a vector,
+// internal cycles are either null or flagged "---"
+func (v *vector) expectedCycles() []busCycle {
+	var cycles []busCycle
+	for _, cycle := range v.Cycles {
+		if len(cycle) < 3 || cycle[1] == nil {
+			cycles = append(cycles, busCycle{internal: true})
+			continue
+		}
+		kind, _ := cycle[2].(string)
+		address, _ := cycle[0].(float64)
+		value, _ := cycle[1].(float64)
+		switch {
+		case len(kind) > 0 && kind[0] == 'r':
+			cycles = append(cycles, busCycle{address: uint16(address), value: byte(value)})
+		case len(kind) > 1 && kind[1] == 'w':
+			cycles = append(cycles, busCycle{address: uint16(address), value: byte(value), write: true})
+		default:
+			cycles = append(cycles, busCycle{internal: true})
+		}
+	}
+	return cycles
+}
+
+func (v *vector) run() []string {
+	mem := &flatMemory{}
+	for _, cell := range v.Initial.RAM {
+		mem.ram[cell[0]] = byte(cell[1])
+	}
+	clk := &clock{}
+	clk.attach(mem)
+	i := v.Initial
+	c := cpu{mem: mem, clock: clk, irq: &interrupts{enable: i.IE}, A: i.A, B: i.B, C: i.C, D: i.D, E: i.E, F: i.F, H: i.H, L: i.L,
+		PC: i.PC, SP: i.SP, ime: i.IME == 1}
+
+	length := c.processOpcode()
+
+	var errors []string
+	if length != len(v.Cycles) {
+		errors = append(errors, fmt.Sprintf("length: expected %d cycles, got %d", len(v.Cycles), length))
+	}
+	f := v.Final
+	registers := []struct {
+		name             string
+		expected, result uint16
+	}{
+		{"A", uint16(f.A), uint16(c.A)}, {"B", uint16(f.B), uint16(c.B)},
+		{"C", uint16(f.C), uint16(c.C)}, {"D", uint16(f.D), uint16(c.D)},
+		{"E", uint16(f.E), uint16(c.E)}, {"F", uint16(f.F), uint16(c.F)},
+		{"H", uint16(f.H), uint16(c.H)}, {"L", uint16(f.L), uint16(c.L)},
+		{"PC", f.PC, c.PC}, {"SP", f.SP, c.SP},
+	}
+	for _, r := range registers {
+		if r.expected != r.result {
+			errors = append(errors, fmt.Sprintf("%s: expected %#x, got %#x", r.name, r.expected, r.result))
+		}
+	}
+	// The vectors do not model the delay of EI
+	if ime := c.ime || c.eiDelay; (f.IME == 1) != ime {
+		errors = append(errors, fmt.Sprintf("IME: expected %v, got %v", f.IME == 1, ime))
+	}
+	for _, cell := range f.RAM {
+		if value := mem.ram[cell[0]]; value != byte(cell[1]) {
+			errors = append(errors, fmt.Sprintf("(%#04x): expected %#02x, got %#02x", cell[0], cell[1], value))
+		}
+	}
+
+	expected := v.expectedCycles()
+	for n := 0; n < len(expected) || n < len(mem.cycles); n++ {
+		switch {
+		case n >= len(mem.cycles):
+			errors = append(errors, fmt.Sprintf("cycle %d: expected %v, got nothing", n, expected[n]))
+		case n >= len(expected):
+			errors = append(errors, fmt.Sprintf("cycle %d: unexpected %v", n, mem.cycles[n]))
+		case expected[n] != mem.cycles[n]:
+			errors = append(errors, fmt.Sprintf("cycle %d: expected %v, got %v", n, expected[n], mem.cycles[n]))
+		}
+	}
+	return errors
+}
+
+func TestSingleStepVectors(t *testing.T) {
+	dir := os.Getenv("SM83_TESTS")
+	if dir == "" {
+		dir = defaultVectorsDir
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(files) == 0 {
+		t.Skip("No single-step vectors found in", dir)
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var vectors []vector
+		if err := json.Unmarshal(data, &vectors); err != nil {
+			t.Fatal(file, err)
+		}
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			failures := 0
+			for _, v := range vectors {
+				errors := v.run()
+				if len(errors) == 0 {
+					continue
+				}
+				// Only detail the first failures, an opcode usually fails the same way
+				if failures < 3 {
+					t.Errorf("%s:", v.Name)
+					for _, e := range errors {
+						t.Log("   ", e)
+					}
+				}
+				failures++
+			}
+			if failures > 0 {
+				t.Errorf("%d/%d vectors failed", failures, len(vectors))
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package goboy
+
+// Durations of the OAM DMA, in M-cycles
+const (
+	oamDMALength = 0xa0
+	oamDMADelay  = 2 // the first byte is copied 2 cycles after the write
+)
+
+// oamDMA copies 160 bytes to the OAM, one per M-cycle.
+// While it runs, the CPU can only access the HRAM.
+type oamDMA struct {
+	mem      *memory
+	register byte // last value written to 0xff46
+	source   uint16
+	index    int // next byte to copy
+	active   bool
+	delay    int // cycles before a requested transfer starts
+}
+
+// blocks tells if the CPU can not access an address
+func (d *oamDMA) blocks(address uint16) bool {
+	return d.active && address < 0xff80
+}
+
+func (d *oamDMA) write(value byte) {
+	d.register = value
+	d.delay = oamDMADelay // a running transfer goes on until the new one starts
+}
+
+func (d *oamDMA) step() {
+	if d.active {
+		address := d.source + uint16(d.index)
+		if address >= 0xe000 { // the DMA sees the WRAM above 0xe000
+			address -= 0x2000
+		}
+		d.mem.ppu.oam[d.index] = d.mem.read(address)
+		d.index++
+		d.active = d.index < oamDMALength
+	}
+	if d.delay > 0 {
+		d.delay--
+		if d.delay == 0 {
+			d.active = true
+			d.source = uint16(d.register) << 8
+			d.index = 0
+		}
+	}
+}
+
+// hdmaBlock is the number of bytes copied at each HBlank
+const hdmaBlock = 0x10
+
+// hdma is the VRAM DMA of the CGB, set up with HDMA1-HDMA5. The general
+// purpose DMA copies everything at once, the HBlank DMA copies a block
+// at the beginning of each HBlank. The CPU is stalled while they copy,
+// they copy 2 bytes per M-cycle (1 in double speed).
+type hdma struct {
+	mem       *memory
+	source    uint16
+	dest      uint16 // offset in the VRAM
+	remaining int    // blocks left to copy
+	hblank    bool   // a HBlank DMA is running
+	copying   int    // bytes to copy before the CPU runs again
+	starting  int    // bytes to copy from the next cycle
+	mode      byte   // mode of the PPU on the last cycle
+}
+
+// stalled tells if the CPU waits for the DMA
+func (h *hdma) stalled() bool {
+	return h.copying > 0
+}
+
+func (h *hdma) read(address uint16) byte {
+	if address != 0xff55 { // HDMA1-HDMA4 are write only
+		return 0xff
+	}
+	switch {
+	case h.remaining == 0:
+		return 0xff
+	case h.hblank:
+		return byte(h.remaining-1) & 0x7f
+	default: // stopped
+		return 0x80 | byte(h.remaining-1)
+	}
+}
+
+func (h *hdma) write(address uint16, value byte) {
+	switch address {
+	case 0xff51:
+		h.source = h.source&0x00ff | uint16(value)<<8
+	case 0xff52:
+		h.source = h.source&0xff00 | uint16(value&0xf0)
+	case 0xff53:
+		h.dest = h.dest&0x00ff | uint16(value&0x1f)<<8
+	case 0xff54:
+		h.dest = h.dest&0xff00 | uint16(value&0xf0)
+	case 0xff55:
+		if h.hblank && value&0x80 == 0 { // stops the HBlank DMA
+			h.hblank = false
+			return
+		}
+		h.remaining = int(value&0x7f) + 1
+		if value&0x80 == 0 { // general purpose
+			h.starting = h.remaining * hdmaBlock
+			return
+		}
+		h.hblank = true
+		// A block is copied right away in HBlank or when the LCD is off
+		if p := h.mem.ppu; p.lcdc&lcdcEnable == 0 || p.mode == modeHBlank {
+			h.starting = hdmaBlock
+		}
+	}
+}
+
+func (h *hdma) step() {
+	for i := h.mem.speed.dots() / 2; i > 0 && h.copying > 0; i-- {
+		h.copy()
+	}
+	if h.starting > 0 {
+		h.copying, h.starting = h.starting, 0
+	}
+
+	p := h.mem.ppu
+	if h.hblank && h.copying == 0 && p.mode != h.mode && p.mode == modeHBlank &&
+		p.ly < ScreenHeight && p.lcdc&lcdcEnable != 0 {
+		h.starting = hdmaBlock
+	}
+	h.mode = p.mode
+}
+
+// copy copies a byte to the current bank of the VRAM
+func (h *hdma) copy() {
+	p := h.mem.ppu
+	p.vram[int(p.vbk)*0x2000+int(h.dest&0x1fff)] = h.mem.read(h.source)
+	h.source++
+	h.dest = (h.dest + 1) & 0x1fff
+	h.copying--
+	if h.copying%hdmaBlock == 0 {
+		h.remaining--
+		if h.remaining == 0 {
+			h.hblank = false
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package goboy
+
+import "testing"
+
+// newTestGameBoy returns a GameBoy running from the internal RAM,
+// in CGB mode if asked
+func newTestGameBoy(t *testing.T, cgb bool) gameBoy {
+	rom := make([]byte, 0x8000)
+	if cgb {
+		rom[0x143] = 0x80
+	}
+	gb, err := NewGameBoy(&rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gb.Memory.boot = nil
+	gb.CPU.PC = 0xc000
+	gb.CPU.SP = 0xfffe
+	return gb
+}
+
+func TestOAMDMA(t *testing.T) {
+	gb := newTestGameBoy(t, false)
+	m := gb.Memory
+	for i := 0; i < oamDMALength; i++ {
+		m.Write(0xc100+uint16(i), byte(i))
+	}
+	m.Write(0xff80, 42)
+	m.Write(0xff46, 0xc1)
+	gb.Clock.tick()
+	if m.Read(0xc100) != 0 {
+		t.Error("Expected the bus to be free before the transfer starts")
+	}
+	gb.Clock.tick()
+	if m.Read(0xc100) != 0xff || m.Read(0xff80) != 42 {
+		t.Error("Expected only the HRAM to be accessible during the transfer")
+	}
+	for i := 0; i < oamDMALength; i++ {
+		gb.Clock.tick()
+	}
+	if m.Read(0xc100) != 0 || m.Read(0xff46) != 0xc1 {
+		t.Error("Expected the bus to be free after 160 cycles")
+	}
+	if gb.PPU.oam[0] != 0 || gb.PPU.oam[oamDMALength-1] != oamDMALength-1 {
+		t.Error("Expected the OAM to be copied, got", gb.PPU.oam[:8])
+	}
+}
+
+func TestGeneralPurposeDMA(t *testing.T) {
+	gb := newTestGameBoy(t, true)
+	m := gb.Memory
+	for i := 0; i < 0x20; i++ {
+		m.Write(0xd000+uint16(i), byte(i+1))
+	}
+	for i, b := range []byte{0xd0, 0x00, 0x01, 0x00} { // from 0xd000 to 0x8100
+		m.Write(0xff51+uint16(i), b)
+	}
+	copy(m.wram[:], []byte{0x3e, 0x01, 0xe0, 0x55}) // LD A, 1; LDH (HDMA5), A
+	gb.CPU.processOpcode()
+	if cycles := gb.CPU.processOpcode(); cycles != 3+16 {
+		t.Error("Expected the CPU to be stalled 16 cycles for 32 bytes, got", cycles-3)
+	}
+	if gb.PPU.vram[0x100] != 1 || gb.PPU.vram[0x11f] != 0x20 || gb.PPU.vram[0x120] != 0 {
+		t.Error("Expected 32 bytes in the VRAM, got", gb.PPU.vram[0x100:0x121])
+	}
+	if hdma5 := m.Read(0xff55); hdma5 != 0xff {
+		t.Errorf("Expected the transfer to be complete, got HDMA5=%#02x", hdma5)
+	}
+}
+
+func TestHBlankDMA(t *testing.T) {
+	gb := newTestGameBoy(t, true)
+	m := gb.Memory
+	for i := 0; i < 0x30; i++ {
+		m.Write(0xd000+uint16(i), byte(i+1))
+	}
+	for i, b := range []byte{0xd0, 0x00, 0x00, 0x00} {
+		m.Write(0xff51+uint16(i), b)
+	}
+	m.Write(0xff40, lcdcEnable)
+	m.Write(0xff55, 0x82) // 3 blocks
+
+	for gb.PPU.mode != modeHBlank {
+		gb.CPU.tick()
+	}
+	gb.CPU.tick() // the copy starts on the next cycle
+	if gb.PPU.vram[0x0f] != 0x10 || gb.PPU.vram[0x10] != 0 || m.Read(0xff55) != 0x01 {
+		t.Errorf("Expected a block copied at the first HBlank, HDMA5=%#02x", m.Read(0xff55))
+	}
+
+	// Stopped after the second block, the remaining blocks are kept
+	for gb.PPU.ly != 1 || gb.PPU.mode != modeDrawing {
+		gb.CPU.tick()
+	}
+	for gb.PPU.mode != modeHBlank {
+		gb.CPU.tick()
+	}
+	gb.CPU.tick() // the copy starts on the next cycle
+	m.Write(0xff55, 0x00)
+	if hdma5 := m.Read(0xff55); hdma5 != 0x80 || gb.PPU.vram[0x1f] != 0x20 {
+		t.Errorf("Expected the DMA to be stopped with a block left, got HDMA5=%#02x", hdma5)
+	}
+	gb.PPU.runLines(2)
+	if gb.PPU.vram[0x20] != 0 {
+		t.Error("Expected no copy once stopped")
+	}
+}
@@ -0,0 +1,139 @@
+package goboy
+
+// Registers is a snapshot of the registers of the CPU
+type Registers struct {
+	A, F, B, C, D, E, H, L byte
+	SP, PC                 uint16
+	IME                    bool
+	Halted                 bool
+}
+
+// Emulator is the public interface to a Game Boy, to embed in other tools.
+// Durations are counted in M-cycles, there are 1048576 of them per second.
+type Emulator struct {
+	gb      gameBoy
+	buttons Button
+}
+
+// NewEmulator loads a ROM and returns an emulator ready to run it
+func NewEmulator(rom []byte, options ...Option) (*Emulator, error) {
+	gb, err := NewGameBoy(&rom, options...)
+	if err != nil {
+		return nil, err
+	}
+	e := &Emulator{gb: gb}
+	if e.gb.Joypad.input == nil {
+		e.gb.SetInput(InputFunc(func() Button { return e.buttons }))
+	}
+	return e, nil
+}
+
+// StepInstruction executes a single instruction, or services an interrupt,
+// and returns the number of M-cycles it took. Once a Fault is returned,
+// the emulator can not run anymore.
+func (e *Emulator) StepInstruction() (cycles int, err error) {
+	cycles = e.gb.CPU.processOpcode()
+	return cycles, e.fault()
+}
+
+// StepFrame runs until the PPU completes a frame. When the LCD is off,
+// it runs for the duration of a frame instead.
+func (e *Emulator) StepFrame() error {
+	frames := e.gb.PPU.frames
+	end := e.gb.Clock.cycles + cyclesPerFrame
+	for e.gb.PPU.frames == frames && (e.gb.PPU.lcdc&lcdcEnable != 0 || e.gb.Clock.cycles < end) {
+		e.gb.CPU.processOpcode()
+		if err := e.fault(); err != nil {
+			return err
+		}
+	}
+	return e.gb.autosave()
+}
+
+// RunFor runs at least the given number of M-cycles, the last
+// instruction may go past it
+func (e *Emulator) RunFor(cycles uint64) error {
+	end := e.gb.Clock.cycles + cycles
+	for e.gb.Clock.cycles < end {
+		e.gb.CPU.processOpcode()
+		if err := e.fault(); err != nil {
+			return err
+		}
+	}
+	return e.gb.autosave()
+}
+
+// Flush writes the battery-backed state of the cartridge to the save file,
+// StepFrame and RunFor do it periodically. Call it before exiting.
+func (e *Emulator) Flush() error {
+	return e.gb.Flush()
+}
+
+// fault returns the fault that stopped the CPU, if any
+func (e *Emulator) fault() error {
+	if e.gb.CPU.fault != nil {
+		return e.gb.CPU.fault
+	}
+	return nil
+}
+
+// Cycles returns the number of M-cycles elapsed since power on
+func (e *Emulator) Cycles() uint64 {
+	return e.gb.Clock.cycles
+}
+
+// Model returns the model emulated, given or detected from the cartridge
+func (e *Emulator) Model() Model {
+	return e.gb.Model
+}
+
+// Registers returns the current state of the registers of the CPU
+func (e *Emulator) Registers() Registers {
+	c := e.gb.CPU
+	return Registers{
+		A: c.A, F: c.F, B: c.B, C: c.C, D: c.D, E: c.E, H: c.H, L: c.L,
+		SP: c.SP, PC: c.PC, IME: c.ime, Halted: c.halted,
+	}
+}
+
+// ReadMemory returns a byte of the address space, as the CPU would read it
+func (e *Emulator) ReadMemory(address uint16) byte {
+	return e.gb.Memory.Read(address)
+}
+
+// Frame returns a copy of the last frame completed by the PPU
+func (e *Emulator) Frame() Frame {
+	return *e.gb.Frame()
+}
+
+// SGBFrame returns a copy of the last picture of the Super Game Boy,
+// with its border. It returns false when the game does not run in SGB mode.
+func (e *Emulator) SGBFrame() (SGBFrame, bool) {
+	if frame := e.gb.SGBFrame(); frame != nil {
+		return *frame, true
+	}
+	return SGBFrame{}, false
+}
+
+// Frames returns the number of frames completed since power on
+func (e *Emulator) Frames() uint64 {
+	return e.gb.PPU.frames
+}
+
+// Samples returns the stereo audio samples produced since the last call,
+// interleaved with the left channel first
+func (e *Emulator) Samples() []int16 {
+	return e.gb.Samples()
+}
+
+// SetInput changes the provider of the keys pressed on the joypad
+func (e *Emulator) SetInput(input InputProvider) {
+	e.gb.SetInput(input)
+}
+
+// SetButtons sets the keys pressed, for the hosts that do not need
+// an input provider. It replaces the current provider.
+func (e *Emulator) SetButtons(buttons Button) {
+	e.buttons = buttons
+	e.gb.SetInput(InputFunc(func() Button { return e.buttons }))
+}
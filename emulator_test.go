@@ -0,0 +1,77 @@
+package goboy
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewEmulatorErrors(t *testing.T) {
+	if _, err := NewEmulator(make([]byte, 0x100)); !errors.Is(err, ErrInvalidROM) {
+		t.Error("Expected ErrInvalidROM, got", err)
+	}
+	rom := make([]byte, 0x8000)
+	rom[0x147] = 0xfe
+	if _, err := NewEmulator(rom); !errors.Is(err, ErrUnsupportedCartridge) {
+		t.Error("Expected ErrUnsupportedCartridge, got", err)
+	}
+}
+
+func TestEmulatorStepping(t *testing.T) {
+	e, err := NewEmulator(make([]byte, 0x8000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cycles, err := e.StepInstruction()
+	if err != nil || cycles != 3 || e.Registers().SP != 0xfffe {
+		t.Errorf("Expected LD SP, 0xfffe to take 3 cycles, got %d cycles, SP=%#04x", cycles, e.Registers().SP)
+	}
+	if err := e.RunFor(1000); err != nil || e.Cycles() < 1003 {
+		t.Error("Expected to run for 1000 cycles, got", e.Cycles(), err)
+	}
+	// The boot ROM turns the LCD on after a few frames
+	for i := 0; e.Frames() == 0; i++ {
+		if err := e.StepFrame(); err != nil || i == 10 {
+			t.Fatal("Expected the LCD to be turned on", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := e.StepFrame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if e.Frames() != 4 {
+		t.Error("Expected 4 frames, got", e.Frames())
+	}
+	if value := e.ReadMemory(0xc000); value != 0 {
+		t.Error("Expected to read memory, got", value)
+	}
+}
+
+func TestEmulatorButtons(t *testing.T) {
+	e, err := NewEmulator(make([]byte, 0x8000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetButtons(ButtonStart)
+	e.gb.Memory.Write(0xff00, 0x10)
+	if value := e.ReadMemory(0xff00); value != 0xd7 {
+		t.Errorf("Expected Start to be pressed, got %#02x", value)
+	}
+}
+
+func TestEmulatorFault(t *testing.T) {
+	e, err := NewEmulator(make([]byte, 0x8000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.gb.Memory.Write(0xc000, 0xdd)
+	e.gb.CPU.PC = 0xc000
+	_, err = e.StepInstruction()
+	var fault *Fault
+	if !errors.As(err, &fault) || !errors.Is(err, ErrIllegalOpcode) || fault.PC != 0xc000 || fault.Opcode != 0xdd {
+		t.Fatal("Expected an illegal opcode fault, got", err)
+	}
+	if err := e.StepFrame(); err != fault {
+		t.Error("Expected the fault to stop the emulator, got", err)
+	}
+}
@@ -0,0 +1,32 @@
+package goboy
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the emulator
+var (
+	ErrInvalidROM           = errors.New("goboy: invalid ROM")
+	ErrUnsupportedCartridge = errors.New("goboy: unsupported cartridge type")
+	ErrIllegalOpcode        = errors.New("goboy: illegal opcode")
+	ErrInvalidSave          = errors.New("goboy: invalid save file")
+	ErrInvalidBootROM       = errors.New("goboy: invalid boot ROM")
+)
+
+// Fault is a fatal error of the emulated hardware, it stops the emulation.
+// Use errors.Is to find its cause.
+type Fault struct {
+	Err    error
+	PC     uint16 // address of the faulty instruction
+	Opcode byte
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("%v %#02x at %#04x", f.Err, f.Opcode, f.PC)
+}
+
+// Unwrap returns the cause of the fault
+func (f *Fault) Unwrap() error {
+	return f.Err
+}
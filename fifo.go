@@ -0,0 +1,232 @@
+package goboy
+
+// Duration of the fetches of the pixel FIFO renderer, in dots
+const (
+	firstFetchDots  = 6 // the first tile of a line is fetched twice
+	spriteFetchDots = 6
+)
+
+// fifoPixel is a pixel waiting in one of the FIFOs
+type fifoPixel struct {
+	color    byte // color number, before the palette is applied
+	palette  byte // CGB palette number, or the DMG palette bit of a sprite
+	priority bool // sprite behind BG colors 1-3, or BG over the sprites
+	index    int  // position of the sprite in the OAM, for the CGB priority
+}
+
+// fetcher fetches the BG and window tiles, 8 pixels at a time.
+// Each of its steps (tile number, low and high data) takes 2 dots.
+type fetcher struct {
+	step      int
+	x         int // tile column
+	window    bool
+	tile      byte
+	attrs     byte // CGB attributes of the tile
+	low, high byte
+}
+
+// pixelFIFO renders a scanline dot by dot, the way the hardware does it.
+// The length of mode 3 depends on SCX, the window and the sprites, and
+// the registers written during mode 3 are used from the next pixel.
+type pixelFIFO struct {
+	fetcher     fetcher
+	bg          []fifoPixel
+	obj         []fifoPixel
+	sprites     []sprite // sprites of the line, not fetched yet
+	x           int      // next pixel to draw
+	discard     int      // pixels dropped for the fine scroll of SCX
+	wait        int
+	spriteDots  int // remaining dots of the current sprite fetch
+	windowDrawn bool
+}
+
+// start prepares the rendering of the current line of the PPU
+func (f *pixelFIFO) start(p *ppu) {
+	f.fetcher = fetcher{}
+	f.bg = f.bg[:0]
+	f.obj = f.obj[:0]
+	f.x = 0
+	f.discard = int(p.scx & 7)
+	f.wait = firstFetchDots
+	f.spriteDots = 0
+	f.windowDrawn = false
+
+	// Sprites are fetched from left to right, then in OAM order
+	f.sprites = p.scanSprites()
+	for i := 1; i < len(f.sprites); i++ {
+		for j := i; j > 0 && f.sprites[j].x < f.sprites[j-1].x; j-- {
+			f.sprites[j], f.sprites[j-1] = f.sprites[j-1], f.sprites[j]
+		}
+	}
+}
+
+// tick advances the rendering by one dot, it returns true
+// when the line is complete
+func (f *pixelFIFO) tick(p *ppu) bool {
+	switch {
+	case f.wait > 0:
+		f.wait--
+		return false
+	case f.spriteDots > 0:
+		f.spriteDots--
+		if f.spriteDots == 0 {
+			f.mergeSprite(p, f.sprites[0])
+			f.sprites = f.sprites[1:]
+		}
+		return false
+	}
+
+	// The window restarts the fetcher as soon as it is reached
+	if p.lcdc&lcdcWindowEnable != 0 && (p.lcdc&lcdcBGEnable != 0 || p.cgb) && p.windowY &&
+		!f.fetcher.window && f.x >= int(p.wx)-7 {
+		f.fetcher = fetcher{window: true}
+		f.bg = f.bg[:0]
+		f.discard = 0
+		f.windowDrawn = true
+	}
+
+	// A sprite stops the output until it is fetched, which can only
+	// start once the BG fetcher is reading its last tile data
+	if len(f.sprites) > 0 && f.sprites[0].x <= f.x {
+		if p.lcdc&lcdcOBJEnable == 0 {
+			f.sprites = f.sprites[1:]
+		} else if len(f.bg) > 0 {
+			if f.fetcher.step < 5 {
+				f.fetch(p)
+			} else {
+				f.spriteDots = spriteFetchDots - 1 // this dot is the first one
+			}
+			return false
+		}
+	}
+
+	if len(f.bg) > 0 {
+		bg := f.bg[0]
+		f.bg = f.bg[1:]
+		if f.discard > 0 {
+			f.discard--
+		} else {
+			f.draw(p, bg)
+		}
+	}
+	f.fetch(p)
+
+	if f.x == ScreenWidth {
+		if f.windowDrawn {
+			p.windowLine++
+		}
+		return true
+	}
+	return false
+}
+
+// draw mixes a BG pixel with the sprites and outputs it
+func (f *pixelFIFO) draw(p *ppu, bg fifoPixel) {
+	if p.lcdc&lcdcBGEnable == 0 && !p.cgb {
+		bg.color = 0
+	}
+	color := p.bgColor(bg)
+	if len(f.obj) > 0 {
+		obj := f.obj[0]
+		f.obj = f.obj[1:]
+		if obj.color != 0 && p.lcdc&lcdcOBJEnable != 0 && !p.bgOverSprite(bg, obj.priority) {
+			color = p.objColor(obj)
+		}
+	}
+	p.back[p.ly][f.x] = color
+	f.x++
+}
+
+// fetch advances the BG fetcher by one dot
+func (f *pixelFIFO) fetch(p *ppu) {
+	fe := &f.fetcher
+	if fe.step < 6 {
+		fe.step++
+	}
+	switch fe.step {
+	case 2:
+		address := f.tileAddress(p)
+		fe.tile = p.vram[address]
+		fe.attrs = p.mapAttributes(address)
+	case 4, 6:
+		var row int
+		if fe.window {
+			row = p.windowLine % 8
+		} else {
+			row = (int(p.ly) + int(p.scy)) % 8
+		}
+		low, high := p.tileRow(fe.tile, row, fe.attrs, false)
+		if fe.step == 4 {
+			fe.low = low
+		} else {
+			fe.high = high
+		}
+	}
+
+	// The fetcher only pushes to an empty FIFO
+	if fe.step == 6 && len(f.bg) == 0 {
+		for x := 0; x < 8; x++ {
+			column := x
+			if fe.attrs&attrXFlip != 0 {
+				column = 7 - x
+			}
+			f.bg = append(f.bg, fifoPixel{pixel(fe.low, fe.high, column), fe.attrs & attrCGBPalette, fe.attrs&attrPriority != 0, 0})
+		}
+		fe.x++
+		fe.step = 0
+	}
+}
+
+// tileAddress returns the address in the tile map of the tile to fetch
+func (f *pixelFIFO) tileAddress(p *ppu) int {
+	fe := &f.fetcher
+	if fe.window {
+		address := 0x1800
+		if p.lcdc&lcdcWindowTileMap != 0 {
+			address = 0x1c00
+		}
+		return address + (p.windowLine/8)*32 + fe.x&31
+	}
+	address := 0x1800
+	if p.lcdc&lcdcBGTileMap != 0 {
+		address = 0x1c00
+	}
+	y := (int(p.ly) + int(p.scy)) & 0xff
+	return address + (y/8)*32 + (int(p.scx>>3)+fe.x)&31
+}
+
+// mergeSprite adds the pixels of a sprite to the sprite FIFO, the pixels
+// of the sprites already there have priority on DMG. On CGB, the first
+// sprite in OAM has priority.
+func (f *pixelFIFO) mergeSprite(p *ppu, s sprite) {
+	height := 8
+	if p.lcdc&lcdcOBJSize != 0 {
+		height = 16
+	}
+	row := int(p.ly) - s.y
+	if s.attrs&attrYFlip != 0 {
+		row = height - 1 - row
+	}
+	tile := s.tile
+	if height == 16 {
+		tile &= 0xfe
+	}
+	low, high := p.tileRow(tile, row, s.attrs, true)
+
+	for column := f.x - s.x; column < 8; column++ {
+		x := column
+		if s.attrs&attrXFlip != 0 {
+			x = 7 - column
+		}
+		px := fifoPixel{pixel(low, high, x), p.spritePalette(s.attrs), s.attrs&attrPriority != 0, s.index}
+		i := column - (f.x - s.x)
+		switch {
+		case i >= len(f.obj):
+			f.obj = append(f.obj, px)
+		case f.obj[i].color == 0:
+			f.obj[i] = px
+		case p.cgb && px.color != 0 && px.index < f.obj[i].index:
+			f.obj[i] = px
+		}
+	}
+}
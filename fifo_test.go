@@ -0,0 +1,94 @@
+package goboy
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// mode3Length returns the number of dots of mode 3 on the next line
+func mode3Length(p *ppu) int {
+	for p.mode != modeDrawing {
+		p.tickDot()
+	}
+	dots := 0
+	for p.mode == modeDrawing {
+		p.tickDot()
+		dots++
+	}
+	return dots
+}
+
+func TestMode3Length(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(p *ppu)
+		dots  int
+	}{
+		{"plain", func(p *ppu) {}, 172},
+		{"SCX fine scroll", func(p *ppu) { p.scx = 3 }, 175},
+		{"sprite on a tile boundary", func(p *ppu) { copy(p.oam[:], []byte{16, 8, 0, 0}) }, 183},
+		{"sprite late in a tile", func(p *ppu) { copy(p.oam[:], []byte{16, 13, 0, 0}) }, 178},
+		{"window", func(p *ppu) {
+			p.lcdc |= lcdcWindowEnable
+			p.wx = 87
+		}, 178},
+	}
+	for _, test := range tests {
+		p := newTestPPU()
+		p.fifo = &pixelFIFO{}
+		test.setup(p)
+		if dots := mode3Length(p); dots != test.dots {
+			t.Errorf("%s: expected mode 3 to last %d dots, got %d", test.name, test.dots, dots)
+		}
+	}
+}
+
+func TestMidScanlinePaletteChange(t *testing.T) {
+	p := newTestPPU()
+	p.fifo = &pixelFIFO{}
+	p.bgp = 0x00
+	for p.mode != modeDrawing {
+		p.tickDot()
+	}
+	for i := 0; i < 100; i++ {
+		p.tickDot()
+	}
+	p.write(0xff47, 0xff)
+	p.runLines(ScreenHeight)
+
+	line := p.front[0]
+	if line[0] != dmgColors[0] || line[ScreenWidth-1] != dmgColors[3] {
+		t.Error("Expected the palette change to happen in the middle of the line")
+	}
+	if line[87] != dmgColors[0] || line[88] != dmgColors[3] {
+		t.Error("Expected the palette change at x=88, got", line[80:96])
+	}
+}
+
+// Both renderers draw the same frame when the registers do not change
+func TestRenderersMatch(t *testing.T) {
+	scanline := newTestPPU()
+	fifo := newTestPPU()
+	fifo.fifo = &pixelFIFO{}
+
+	r := rand.New(rand.NewSource(42))
+	for _, p := range []*ppu{scanline, fifo} {
+		r.Seed(42)
+		r.Read(p.vram[:])
+		for i := range p.oam {
+			p.oam[i] = byte(r.Intn(168))
+		}
+		p.lcdc |= lcdcWindowEnable | lcdcOBJSize
+		p.scx, p.scy, p.wx, p.wy = 13, 7, 60, 40
+		p.obp1 = 0x1b
+		p.runLines(ScreenHeight)
+	}
+
+	for y := range scanline.front {
+		for x := range scanline.front[y] {
+			if scanline.front[y][x] != fifo.front[y][x] {
+				t.Fatalf("Renderers differ at (%d, %d)", x, y)
+			}
+		}
+	}
+}
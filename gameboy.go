@@ -4,26 +4,247 @@ package goboy
 type gameBoy struct {
 	CPU    *cpu
 	Memory *memory
+	Clock  *clock
+	IRQ    *interrupts
+	Timer  *timer
+	PPU    *ppu
+	APU    *apu
+	Joypad *joypad
+	Serial *serial
+	SGB    *sgb      // nil when not in SGB mode
+	Save   *saveFile // nil when the cartridge state is not persisted
+	Model  Model
+
+	noBootROM bool
+}
+
+// Option configures a GameBoy at construction time
+type Option func(gb *gameBoy)
+
+// WithPixelFIFO renders with the pixel FIFO instead of whole scanlines.
+// It is slower but mid-scanline effects and the length of mode 3 are accurate.
+func WithPixelFIFO() Option {
+	return func(gb *gameBoy) {
+		gb.PPU.fifo = &pixelFIFO{}
+	}
+}
+
+// WithSampleRate sets the rate of the audio output, in samples per second
+func WithSampleRate(rate int) Option {
+	return func(gb *gameBoy) {
+		gb.APU.sampleRate = rate
+	}
+}
+
+// WithInput sets the provider of the keys pressed on the joypad
+func WithInput(input InputProvider) Option {
+	return func(gb *gameBoy) {
+		gb.SetInput(input)
+	}
+}
+
+// WithTimeSource sets the time source of the real-time clock of
+// the cartridge, if it has one
+func WithTimeSource(source TimeSource) Option {
+	return func(gb *gameBoy) {
+		if m, ok := gb.Memory.mbc.(*MBC3); ok && m.rtc != nil {
+			m.rtc.setSource(source)
+		}
+	}
+}
+
+// WithRumble sets a function called when the rumble motor of
+// the cartridge is turned on or off
+func WithRumble(onRumble func(on bool)) Option {
+	return func(gb *gameBoy) {
+		if m, ok := gb.Memory.mbc.(*MBC5); ok {
+			m.onRumble = onRumble
+		}
+	}
+}
+
+// WithSaveFile loads the battery-backed RAM and RTC of the cartridge
+// from a file, and writes them back periodically and on Flush
+func WithSaveFile(path string) Option {
+	return func(gb *gameBoy) {
+		gb.Save = &saveFile{path: path}
+	}
+}
+
+// WithAtomicSaveFile is WithSaveFile, the file is replaced at once
+// so that a crash during a write can not corrupt it
+func WithAtomicSaveFile(path string) Option {
+	return func(gb *gameBoy) {
+		gb.Save = &saveFile{path: path, atomic: true}
+	}
+}
+
+// WithLinkPeer plugs a peer at the other end of the link cable,
+// nothing is plugged by default
+func WithLinkPeer(peer LinkPeer) Option {
+	return func(gb *gameBoy) {
+		gb.Serial.peer = peer
+	}
+}
+
+// WithModel emulates a given model instead of the one the cartridge
+// was made for
+func WithModel(model Model) Option {
+	return func(gb *gameBoy) {
+		gb.Model = model
+	}
+}
+
+// WithBootROM runs a boot ROM dumped from a console before the game:
+// 256 bytes for the DMG0, DMG, MGB, SGB and SGB2, 2304 bytes for the CGB
+// and AGB. The built-in boot ROM is used on the DMG, the other models
+// start after the boot by default.
+func WithBootROM(boot []byte) Option {
+	return func(gb *gameBoy) {
+		gb.Memory.boot = append([]byte{}, boot...)
+	}
+}
+
+// WithoutBootROM starts the game right away, in the state the boot ROM
+// would have left the console in
+func WithoutBootROM() Option {
+	return func(gb *gameBoy) {
+		gb.Memory.boot = nil
+		gb.noBootROM = true
+	}
 }
 
 // NewGameBoy constructs a GameBoy
-func NewGameBoy(rom *[]byte) gameBoy {
+func NewGameBoy(rom *[]byte, options ...Option) (gameBoy, error) {
 	gb := gameBoy{}
 
-	c := cpu{}
+	clk := clock{}
+	gb.Clock = &clk
+
+	irq := interrupts{}
+	gb.IRQ = &irq
+
+	t := timer{irq: &irq}
+	gb.Timer = &t
+	clk.attach(&t)
+
+	p := ppu{irq: &irq}
+	gb.PPU = &p
+	clk.attach(&p)
+
+	a := apu{timer: &t, sampleRate: DefaultSampleRate}
+	gb.APU = &a
+	clk.attach(&a)
+
+	j := joypad{irq: &irq, selected: 0x30, lines: 0xf}
+	gb.Joypad = &j
+	clk.attach(&j)
+
+	sio := serial{irq: &irq, timer: &t, peer: unplugged{}}
+	gb.Serial = &sio
+	clk.attach(&sio)
+
+	c := cpu{clock: &clk, irq: &irq, onStop: t.resetDiv}
 	gb.CPU = &c
 
-	mem := memory{}
-	mem.loadRom(rom)
+	mem := memory{irq: &irq, timer: &t, ppu: &p, apu: &a, joypad: &j, serial: &sio}
+	if err := mem.loadRom(rom); err != nil {
+		return gb, err
+	}
 	c.mem = &mem
 	gb.Memory = &mem
+	mem.dma.mem = &mem
+	clk.attach(&mem.dma)
+	mem.hdma.mem = &mem
+	clk.attach(&mem.hdma)
 
-	return gb
+	for _, option := range options {
+		option(&gb)
+	}
+
+	if gb.Model == 0 {
+		gb.Model = detectModel(mem.header)
+	}
+	p.model, a.model = gb.Model, gb.Model
+	switch {
+	case gb.Model.cgb(): // starts in CGB mode, the boot ROM switches the DMG games to the DMG mode
+		s := speed{}
+		c.speed, p.speed, a.speed, mem.speed, sio.speed = &s, &s, &s, &s, &s
+		p.cgb, mem.cgb, sio.cgb = true, true, true
+	case gb.Model.sgb() && mem.header.SGB(): // the SGB only listens to the games made for it
+		gb.SGB = newSGB(&p)
+		j.sgb = gb.SGB
+		clk.attach(gb.SGB)
+	}
+
+	if mem.boot == nil && !gb.noBootROM && gb.Model == ModelDMG {
+		mem.boot = dmgBootROM[:]
+	}
+	if mem.boot == nil {
+		gb.skipBoot()
+	} else if len(mem.boot) != gb.Model.bootROMSize() {
+		return gb, ErrInvalidBootROM
+	}
+	if gb.Save != nil {
+		if err := gb.Save.load(mem.mbc); err != nil {
+			return gb, err
+		}
+	}
+
+	return gb, nil
+}
+
+// Frame returns the last frame completed by the PPU
+func (gb *gameBoy) Frame() *Frame {
+	return &gb.PPU.front
+}
+
+// SGBFrame returns the last picture of the Super Game Boy with its border,
+// or nil when not in SGB mode
+func (gb *gameBoy) SGBFrame() *SGBFrame {
+	if gb.SGB == nil {
+		return nil
+	}
+	return &gb.SGB.front
+}
+
+// Samples returns the stereo audio samples produced since the last call,
+// interleaved with the left channel first
+func (gb *gameBoy) Samples() []int16 {
+	return gb.APU.drainSamples()
+}
+
+// SetInput changes the provider of the keys pressed on the joypad,
+// it is polled right away and then once per frame
+func (gb *gameBoy) SetInput(input InputProvider) {
+	gb.Joypad.input = input
+	gb.Joypad.poll()
+}
+
+// Flush writes the state of the cartridge to the save file, if it changed
+func (gb *gameBoy) Flush() error {
+	if gb.Save == nil {
+		return nil
+	}
+	return gb.Save.flush(gb.Memory.mbc)
+}
+
+// autosave flushes the save file periodically
+func (gb *gameBoy) autosave() error {
+	if gb.Save == nil {
+		return nil
+	}
+	return gb.Save.autosave(gb.Memory.mbc, gb.Clock.cycles)
 }
 
-// Run the emulator
-func (gb *gameBoy) Run() {
-	for {
+// Run the emulator until a fault stops the CPU or the save file
+// can not be written
+func (gb *gameBoy) Run() error {
+	for gb.CPU.fault == nil {
 		gb.CPU.processOpcode()
+		if err := gb.autosave(); err != nil {
+			return err
+		}
 	}
+	return gb.CPU.fault
 }
@@ -0,0 +1,284 @@
+package goboy
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// Header is the cartridge header, at 0x100-0x14f of the ROM
+type Header struct {
+	Title          string
+	Manufacturer   string // 4 characters on the late CGB cartridges, empty otherwise
+	CGBFlag        byte   // 0x80: CGB enhanced, 0xc0: CGB only
+	SGBFlag        byte   // 0x03: SGB functions
+	NewLicensee    string // used when OldLicensee is 0x33
+	Cartridge      CartridgeType
+	ROMSizeCode    byte
+	RAMSizeCode    byte
+	Destination    byte // 0x00: Japan, 0x01: overseas
+	OldLicensee    byte
+	Version        byte
+	HeaderChecksum byte
+	GlobalChecksum uint16
+
+	LogoValid           bool // the boot ROM locks up when the logo does not match
+	HeaderChecksumValid bool // and when the header checksum does not either
+	GlobalChecksumValid bool // not checked by the hardware
+}
+
+// headerEnd is the size of the smallest ROM holding a header
+const headerEnd = 0x150
+
+// nintendoLogo is the logo at 0x104, checked by the boot ROM
+var nintendoLogo = [0x30]byte{
+	0xce, 0xed, 0x66, 0x66, 0xcc, 0x0d, 0x00, 0x0b, 0x03, 0x73, 0x00, 0x83, 0x00, 0x0c, 0x00, 0x0d,
+	0x00, 0x08, 0x11, 0x1f, 0x88, 0x89, 0x00, 0x0e, 0xdc, 0xcc, 0x6e, 0xe6, 0xdd, 0xdd, 0xd9, 0x99,
+	0xbb, 0xbb, 0x67, 0x63, 0x6e, 0x0e, 0xec, 0xcc, 0xdd, 0xdc, 0x99, 0x9f, 0xbb, 0xb9, 0x33, 0x3e,
+}
+
+// ParseHeader reads the header of a ROM and verifies its checksums
+func ParseHeader(rom []byte) (Header, error) {
+	if len(rom) < headerEnd {
+		return Header{}, ErrInvalidROM
+	}
+	h := Header{
+		CGBFlag:        rom[0x143],
+		SGBFlag:        rom[0x146],
+		NewLicensee:    trimText(rom[0x144:0x146]),
+		Cartridge:      CartridgeType(rom[0x147]),
+		ROMSizeCode:    rom[0x148],
+		RAMSizeCode:    rom[0x149],
+		Destination:    rom[0x14a],
+		OldLicensee:    rom[0x14b],
+		Version:        rom[0x14c],
+		HeaderChecksum: rom[0x14d],
+		GlobalChecksum: binary.BigEndian.Uint16(rom[0x14e:]),
+	}
+
+	// The title was shortened on CGB, first to make room for the CGB flag,
+	// then for a manufacturer code
+	switch {
+	case h.CGBFlag&0x80 == 0:
+		h.Title = trimText(rom[0x134:0x144])
+	case isManufacturer(rom[0x13f:0x143]):
+		h.Title = trimText(rom[0x134:0x13f])
+		h.Manufacturer = string(rom[0x13f:0x143])
+	default:
+		h.Title = trimText(rom[0x134:0x143])
+	}
+
+	h.LogoValid = string(rom[0x104:0x134]) == string(nintendoLogo[:])
+	h.HeaderChecksumValid = computeHeaderChecksum(rom) == h.HeaderChecksum
+	h.GlobalChecksumValid = computeGlobalChecksum(rom) == h.GlobalChecksum
+	return h, nil
+}
+
+// computeHeaderChecksum computes the checksum of 0x134-0x14c as the boot ROM does
+func computeHeaderChecksum(rom []byte) byte {
+	var sum byte
+	for _, b := range rom[0x134:0x14d] {
+		sum = sum - b - 1
+	}
+	return sum
+}
+
+// computeGlobalChecksum adds all the bytes of the ROM but the checksum itself
+func computeGlobalChecksum(rom []byte) uint16 {
+	var sum uint16
+	for i, b := range rom {
+		if i != 0x14e && i != 0x14f {
+			sum += uint16(b)
+		}
+	}
+	return sum
+}
+
+// trimText converts a padded text of the header
+func trimText(text []byte) string {
+	return strings.TrimRight(string(text), "\x00 ")
+}
+
+// isManufacturer tells if 4 bytes look like a manufacturer code
+func isManufacturer(code []byte) bool {
+	for _, c := range code {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// CGB tells if the game uses the functions of the CGB
+func (h Header) CGB() bool {
+	return h.CGBFlag&0x80 != 0
+}
+
+// CGBOnly tells if the game only runs on a CGB
+func (h Header) CGBOnly() bool {
+	return h.CGBFlag == 0xc0
+}
+
+// SGB tells if the game uses the functions of the SGB, which
+// also needs the new licensee code
+func (h Header) SGB() bool {
+	return h.SGBFlag == 0x03 && h.OldLicensee == 0x33
+}
+
+// Japanese tells if the game was sold in Japan
+func (h Header) Japanese() bool {
+	return h.Destination == 0
+}
+
+// Licensee returns the name of the publisher
+func (h Header) Licensee() string {
+	var name string
+	var ok bool
+	if h.OldLicensee == 0x33 {
+		name, ok = newLicensees[h.NewLicensee]
+	} else {
+		name, ok = oldLicensees[h.OldLicensee]
+	}
+	if !ok {
+		return "Unknown"
+	}
+	return name
+}
+
+// ROMSize returns the size of the ROM in bytes, 0 for an unknown code
+func (h Header) ROMSize() int {
+	return romSize(h.ROMSizeCode)
+}
+
+// RAMSize returns the size of the cartridge RAM in bytes, 0 for an
+// unknown code. The MBC2 has 512 nibbles of RAM and a code of 0.
+func (h Header) RAMSize() int {
+	return ramSize(h.RAMSizeCode)
+}
+
+func romSize(code byte) int {
+	switch {
+	case code <= 0x08:
+		return 0x8000 << code
+	case code == 0x52:
+		return 72 * 0x4000
+	case code == 0x53:
+		return 80 * 0x4000
+	case code == 0x54:
+		return 96 * 0x4000
+	default:
+		return 0
+	}
+}
+
+func ramSize(code byte) int {
+	switch code {
+	case 0x01: // unofficial, 2kB
+		return 0x800
+	case 0x02:
+		return 0x2000
+	case 0x03:
+		return 0x8000
+	case 0x04:
+		return 0x20000
+	case 0x05:
+		return 0x10000
+	default:
+		return 0
+	}
+}
+
+// CartridgeType is the hardware of a cartridge, at 0x147
+type CartridgeType byte
+
+// cartridgeFeatures are the hardware of a cartridge type
+type cartridgeFeatures struct {
+	name       string
+	controller controller
+	ram        bool
+	battery    bool
+	timer      bool
+	rumble     bool
+}
+
+// controller is the kind of memory bank controller of a cartridge
+type controller int
+
+const (
+	noController controller = iota
+	controllerMBC1
+	controllerMBC2
+	controllerMBC3
+	controllerMBC5
+	controllerOther // known, not emulated
+)
+
+var cartridgeTypes = map[CartridgeType]cartridgeFeatures{
+	0x00: {"ROM ONLY", noController, false, false, false, false},
+	0x01: {"MBC1", controllerMBC1, false, false, false, false},
+	0x02: {"MBC1+RAM", controllerMBC1, true, false, false, false},
+	0x03: {"MBC1+RAM+BATTERY", controllerMBC1, true, true, false, false},
+	0x05: {"MBC2", controllerMBC2, true, false, false, false},
+	0x06: {"MBC2+BATTERY", controllerMBC2, true, true, false, false},
+	0x08: {"ROM+RAM", controllerOther, true, false, false, false},
+	0x09: {"ROM+RAM+BATTERY", controllerOther, true, true, false, false},
+	0x0b: {"MMM01", controllerOther, false, false, false, false},
+	0x0c: {"MMM01+RAM", controllerOther, true, false, false, false},
+	0x0d: {"MMM01+RAM+BATTERY", controllerOther, true, true, false, false},
+	0x0f: {"MBC3+TIMER+BATTERY", controllerMBC3, false, true, true, false},
+	0x10: {"MBC3+TIMER+RAM+BATTERY", controllerMBC3, true, true, true, false},
+	0x11: {"MBC3", controllerMBC3, false, false, false, false},
+	0x12: {"MBC3+RAM", controllerMBC3, true, false, false, false},
+	0x13: {"MBC3+RAM+BATTERY", controllerMBC3, true, true, false, false},
+	0x19: {"MBC5", controllerMBC5, false, false, false, false},
+	0x1a: {"MBC5+RAM", controllerMBC5, true, false, false, false},
+	0x1b: {"MBC5+RAM+BATTERY", controllerMBC5, true, true, false, false},
+	0x1c: {"MBC5+RUMBLE", controllerMBC5, false, false, false, true},
+	0x1d: {"MBC5+RUMBLE+RAM", controllerMBC5, true, false, false, true},
+	0x1e: {"MBC5+RUMBLE+RAM+BATTERY", controllerMBC5, true, true, false, true},
+	0x20: {"MBC6", controllerOther, true, true, false, false},
+	0x22: {"MBC7+SENSOR+RUMBLE+RAM+BATTERY", controllerOther, true, true, false, true},
+	0xfc: {"POCKET CAMERA", controllerOther, true, true, false, false},
+	0xfd: {"BANDAI TAMA5", controllerOther, true, true, true, false},
+	0xfe: {"HuC3", controllerOther, true, true, true, false},
+	0xff: {"HuC1+RAM+BATTERY", controllerOther, true, true, false, false},
+}
+
+// Known tells if the cartridge type is in the table
+func (c CartridgeType) Known() bool {
+	_, ok := cartridgeTypes[c]
+	return ok
+}
+
+func (c CartridgeType) String() string {
+	if f, ok := cartridgeTypes[c]; ok {
+		return f.name
+	}
+	return "Unknown"
+}
+
+// RAM tells if the cartridge has external RAM
+func (c CartridgeType) RAM() bool {
+	return cartridgeTypes[c].ram
+}
+
+// Battery tells if the RAM, or the clock, is powered by a battery
+func (c CartridgeType) Battery() bool {
+	return cartridgeTypes[c].battery
+}
+
+// Timer tells if the cartridge has a real-time clock
+func (c CartridgeType) Timer() bool {
+	return cartridgeTypes[c].timer
+}
+
+// Rumble tells if the cartridge has a rumble motor
+func (c CartridgeType) Rumble() bool {
+	return cartridgeTypes[c].rumble
+}
+
+func (c CartridgeType) controller() controller {
+	if f, ok := cartridgeTypes[c]; ok {
+		return f.controller
+	}
+	return controllerOther
+}
@@ -0,0 +1,75 @@
+package goboy
+
+import "testing"
+
+// headerROM returns a 32kB ROM with a valid header
+func headerROM(title string, cgb, cartridge byte) []byte {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x104:], nintendoLogo[:])
+	copy(rom[0x134:], title)
+	rom[0x143] = cgb
+	rom[0x147] = cartridge
+	fixChecksums(rom)
+	return rom
+}
+
+// fixChecksums updates the checksums of a ROM after its header is modified
+func fixChecksums(rom []byte) {
+	rom[0x14d] = computeHeaderChecksum(rom)
+	sum := computeGlobalChecksum(rom)
+	rom[0x14e], rom[0x14f] = byte(sum>>8), byte(sum)
+}
+
+func TestParseHeader(t *testing.T) {
+	rom := headerROM("TETRIS", 0, 0x13)
+	rom[0x14b] = 0x33
+	copy(rom[0x144:], "A4")
+	rom[0x146] = 0x03
+	rom[0x148] = 0x05
+	rom[0x149] = 0x05
+	fixChecksums(rom)
+	rom[0x7fff] = 1 // the global checksum is not updated
+
+	h, err := ParseHeader(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Title != "TETRIS" || h.Licensee() != "Konami" || !h.SGB() || h.CGB() {
+		t.Errorf("Unexpected header %+v", h)
+	}
+	if h.Cartridge.String() != "MBC3+RAM+BATTERY" || !h.Cartridge.Battery() || h.Cartridge.Timer() {
+		t.Error("Unexpected cartridge type", h.Cartridge)
+	}
+	if h.ROMSize() != 0x100000 || h.RAMSize() != 0x10000 {
+		t.Error("Unexpected sizes", h.ROMSize(), h.RAMSize())
+	}
+	if !h.LogoValid || !h.HeaderChecksumValid || h.GlobalChecksumValid {
+		t.Error("Expected only the global checksum to be wrong")
+	}
+	if CartridgeType(0x42).String() != "Unknown" {
+		t.Error("Expected an unknown type")
+	}
+	if romSize(0x52) != 0x120000 || romSize(0x54) != 0x180000 {
+		t.Error("Expected the sizes of 72 and 96 banks")
+	}
+}
+
+func TestParseHeaderCGB(t *testing.T) {
+	rom := headerROM("POKEMON_SLVAAXE", 0x80, 0x10)
+	rom[0x14b] = 0x01
+	fixChecksums(rom)
+	h, _ := ParseHeader(rom)
+	if h.Title != "POKEMON_SLV" || h.Manufacturer != "AAXE" || h.Licensee() != "Nintendo" {
+		t.Errorf("Unexpected header %+v", h)
+	}
+	if !h.CGB() || h.CGBOnly() || !h.HeaderChecksumValid || !h.GlobalChecksumValid {
+		t.Errorf("Unexpected flags %+v", h)
+	}
+	rom[0x104] = 0
+	if h, _ := ParseHeader(rom); h.LogoValid {
+		t.Error("Expected the modified logo to be invalid")
+	}
+	if _, err := ParseHeader(rom[:0x14f]); err != ErrInvalidROM {
+		t.Error("Expected a short ROM to be refused, got", err)
+	}
+}
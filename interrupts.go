@@ -0,0 +1,34 @@
+package goboy
+
+// Interrupt sources, sorted by priority. The bit is the same in IE and IF.
+const (
+	intVBlank byte = 1 << iota
+	intLCDStat
+	intTimer
+	intSerial
+	intJoypad
+)
+
+// interrupts emulates the interrupt controller
+type interrupts struct {
+	enable byte // IE (0xffff)
+	flags  byte // IF (0xff0f)
+}
+
+// request raises an interrupt, it will be serviced if it is enabled
+func (i *interrupts) request(interrupt byte) {
+	i.flags |= interrupt
+}
+
+// pending returns the requested interrupts that are enabled
+func (i *interrupts) pending() byte {
+	return i.enable & i.flags & 0x1f
+}
+
+func (i *interrupts) readFlags() byte {
+	return i.flags | 0xe0 // the 3 upper bits are unused
+}
+
+func (i *interrupts) writeFlags(value byte) {
+	i.flags = value & 0x1f
+}
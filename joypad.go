@@ -0,0 +1,98 @@
+package goboy
+
+// Button is a set of keys of the Game Boy
+type Button byte
+
+// Keys, the directions are on the low nibble and the buttons on the high one
+const (
+	ButtonRight Button = 1 << iota
+	ButtonLeft
+	ButtonUp
+	ButtonDown
+	ButtonA
+	ButtonB
+	ButtonSelect
+	ButtonStart
+)
+
+// InputProvider gives the keys currently pressed. Frontends, scripts
+// and recorded movies all implement it to drive the joypad.
+type InputProvider interface {
+	Buttons() Button
+}
+
+// InputFunc adapts a function to the InputProvider interface
+type InputFunc func() Button
+
+// Buttons calls f
+func (f InputFunc) Buttons() Button {
+	return f()
+}
+
+// cyclesPerFrame is the number of M-cycles of a frame of the LCD
+const cyclesPerFrame = dotsPerLine * linesPerFrame / 4
+
+// joypad emulates P1 (0xff00). The input provider is polled once per frame,
+// so that a frame always sees the same keys.
+type joypad struct {
+	irq      *interrupts
+	sgb      *sgb // receives the packets sent through P1
+	input    InputProvider
+	pressed  Button
+	selected byte // bits 4 (directions) and 5 (buttons), active low
+	lines    byte // last state of the input lines, active low
+	cycles   int
+}
+
+func (j *joypad) step() {
+	j.cycles++
+	if j.cycles >= cyclesPerFrame {
+		j.cycles = 0
+		j.poll()
+	}
+}
+
+// poll reads the keys from the input provider
+func (j *joypad) poll() {
+	if j.input != nil {
+		j.pressed = j.input.Buttons()
+	} else {
+		j.pressed = 0
+	}
+	j.update()
+}
+
+// update raises an interrupt when an input line goes low
+func (j *joypad) update() {
+	pressed := j.pressed
+	if j.sgb != nil && j.sgb.player != 0 { // only the first joypad is emulated
+		pressed = 0
+	}
+	lines := byte(0xf)
+	if j.selected&0x10 == 0 {
+		lines &^= byte(pressed) & 0xf
+	}
+	if j.selected&0x20 == 0 {
+		lines &^= byte(pressed) >> 4
+	}
+	if j.lines&^lines != 0 {
+		j.irq.request(intJoypad)
+	}
+	j.lines = lines
+}
+
+func (j *joypad) read() byte {
+	// With MLT_REQ, the SGB gives the joypad number when none is selected
+	if j.sgb != nil && j.sgb.players > 1 && j.selected == 0x30 {
+		return 0xf0 | byte(0xf-j.sgb.player)
+	}
+	return 0xc0 | j.selected | j.lines
+}
+
+func (j *joypad) write(value byte) {
+	j.selected = value & 0x30
+	if j.sgb != nil {
+		j.sgb.write(value)
+	}
+	j.update()
+}
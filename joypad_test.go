@@ -0,0 +1,44 @@
+package goboy
+
+import "testing"
+
+func TestJoypadSelection(t *testing.T) {
+	j := joypad{irq: &interrupts{}, selected: 0x30, lines: 0xf}
+	j.input = InputFunc(func() Button { return ButtonA | ButtonDown })
+	j.poll()
+	if value := j.read(); value != 0xff {
+		t.Errorf("Expected nothing selected, got %#02x", value)
+	}
+	j.write(0x20) // directions
+	if value := j.read(); value != 0xe7 {
+		t.Errorf("Expected Down, got %#02x", value)
+	}
+	j.write(0x10) // buttons
+	if value := j.read(); value != 0xde {
+		t.Errorf("Expected A, got %#02x", value)
+	}
+}
+
+func TestJoypadInterrupt(t *testing.T) {
+	var pressed Button
+	j := joypad{irq: &interrupts{}, selected: 0x30, lines: 0xf}
+	j.input = InputFunc(func() Button { return pressed })
+	j.write(0x10)
+	pressed = ButtonStart
+	for i := 0; i < cyclesPerFrame-1; i++ {
+		j.step()
+	}
+	if j.irq.flags != 0 {
+		t.Fatal("Expected the input to be polled once per frame")
+	}
+	j.step()
+	if j.irq.flags != intJoypad {
+		t.Error("Expected a joypad interrupt when Start is pressed")
+	}
+	j.irq.flags = 0
+	pressed = 0
+	j.poll()
+	if j.irq.flags != 0 {
+		t.Error("Expected no interrupt when Start is released")
+	}
+}
@@ -0,0 +1,184 @@
+package goboy
+
+import (
+	"io"
+	"net"
+)
+
+// linkQuantum is the time, in dots, each console runs before waiting for
+// the other one: the two clocks never drift apart by more than a scanline
+const linkQuantum = dotsPerLine
+
+// Messages of the link protocol, each one is a kind and a value byte
+const (
+	linkSync     byte = iota + 1 // the sender completed a quantum
+	linkTransfer                 // the sender clocks a transfer of the value
+	linkReply                    // the value answers a transfer
+)
+
+// Link is a link cable to another Game Boy, in the same process or in
+// another one. Both consoles must run concurrently, each one waits for
+// the other at the end of every quantum. A transfer is answered by the
+// other console when it reaches the end of its quantum.
+type Link struct {
+	conn     io.ReadWriteCloser
+	messages chan [2]byte
+	done     chan struct{} // closed with the cable
+	serial   *serial
+	dots     int
+	syncs    int // quanta completed by the other console, not waited for yet
+	err      error
+	readErr  error
+}
+
+// NewLink returns a link cable over a connection to another Game Boy
+func NewLink(conn io.ReadWriteCloser) *Link {
+	l := &Link{conn: conn, messages: make(chan [2]byte, 16), done: make(chan struct{})}
+	go l.receive()
+	return l
+}
+
+// LinkPipe returns the two ends of a link cable, for two Game Boys
+// running in the same process
+func LinkPipe() (*Link, *Link) {
+	a, b := net.Pipe()
+	return NewLink(a), NewLink(b)
+}
+
+// ListenLink waits for another Game Boy to connect to a TCP address
+func ListenLink(address string) (*Link, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewLink(conn), nil
+}
+
+// DialLink connects to a Game Boy waiting on a TCP address
+func DialLink(address string) (*Link, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return NewLink(conn), nil
+}
+
+// WithLink plugs a link cable to another Game Boy
+func WithLink(link *Link) Option {
+	return func(gb *gameBoy) {
+		link.serial = gb.Serial
+		gb.Serial.peer = link
+		gb.Clock.attach(link)
+	}
+}
+
+// receive reads the messages of the other console until the connection
+// is closed
+func (l *Link) receive() {
+	for {
+		var message [2]byte
+		if _, err := io.ReadFull(l.conn, message[:]); err != nil {
+			l.readErr = err
+			close(l.messages)
+			return
+		}
+		select {
+		case l.messages <- message:
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// send writes a message to the other console
+func (l *Link) send(kind, value byte) {
+	if l.err != nil {
+		return
+	}
+	if _, err := l.conn.Write([]byte{kind, value}); err != nil {
+		l.err = err
+	}
+}
+
+// next returns the next message of the other console, it returns false
+// once disconnected
+func (l *Link) next() ([2]byte, bool) {
+	if l.err != nil {
+		return [2]byte{}, false
+	}
+	message, ok := <-l.messages
+	if !ok {
+		l.err = l.readErr
+		if l.err == nil {
+			l.err = io.EOF
+		}
+		return message, false
+	}
+	return message, true
+}
+
+// handle processes a message which is not a reply
+func (l *Link) handle(message [2]byte) {
+	switch message[0] {
+	case linkSync:
+		l.syncs++
+	case linkTransfer:
+		l.send(linkReply, l.serial.receive(message[1]))
+	}
+}
+
+func (l *Link) step() {
+	if l.err != nil {
+		return
+	}
+	l.dots += l.serial.speed.dots()
+	if l.dots < linkQuantum {
+		return
+	}
+	l.dots -= linkQuantum
+	l.send(linkSync, 0)
+	for l.syncs == 0 {
+		message, ok := l.next()
+		if !ok {
+			return
+		}
+		l.handle(message)
+	}
+	l.syncs--
+}
+
+// Exchange sends a byte to the other console and waits for its answer
+func (l *Link) Exchange(out byte) byte {
+	l.send(linkTransfer, out)
+	for {
+		message, ok := l.next()
+		if !ok {
+			return 0xff
+		}
+		if message[0] == linkReply {
+			return message[1]
+		}
+		l.handle(message) // both consoles can start a transfer at once
+	}
+}
+
+// Err returns the error which disconnected the cable, io.EOF when the
+// other console closed it
+func (l *Link) Err() error {
+	return l.err
+}
+
+// Close unplugs the cable, the other console sees it as unplugged too
+func (l *Link) Close() error {
+	select {
+	case <-l.done:
+	default:
+		close(l.done)
+	}
+	return l.conn.Close()
+}
@@ -0,0 +1,114 @@
+package goboy
+
+import (
+	"net"
+	"sync"
+	"testing"
+)
+
+// linkProgram sends a byte through the serial port, waits for the end
+// of the transfer and stores the byte received at 0xc100
+func linkProgram(value, sc byte) []byte {
+	return []byte{
+		0x3e, value, // LD A, value
+		0xe0, 0x01, // LDH (SB), A
+		0x3e, sc, // LD A, sc
+		0xe0, 0x02, // LDH (SC), A
+		0xf0, 0x02, // LDH A, (SC)
+		0xcb, 0x7f, // BIT 7, A
+		0x20, 0xfa, // JR NZ, -6
+		0xf0, 0x01, // LDH A, (SB)
+		0xea, 0x00, 0xc1, // LD (0xc100), A
+		0x18, 0xfe, // JR -2
+	}
+}
+
+// runLinked runs two Game Boys linked together, each one in its own
+// goroutine, and returns the bytes they received
+func runLinked(t *testing.T, a, b *Link) (byte, byte) {
+	var received [2]byte
+	var wg sync.WaitGroup
+	for i, link := range []*Link{a, b} {
+		rom := make([]byte, 0x8000)
+		gb, err := NewGameBoy(&rom, WithLink(link))
+		if err != nil {
+			t.Fatal(err)
+		}
+		gb.Memory.boot = nil
+		gb.CPU.PC = 0xc000
+		gb.CPU.SP = 0xfffe
+		program := linkProgram(0x42, 0x81) // the first one clocks the transfer
+		if i == 1 {
+			program = linkProgram(0x99, 0x80)
+		}
+		for j, value := range program {
+			gb.Memory.Write(0xc000+uint16(j), value)
+		}
+
+		wg.Add(1)
+		go func(i int, gb gameBoy, link *Link) {
+			defer wg.Done()
+			defer link.Close()
+			for gb.Clock.cycles < 4*cyclesPerFrame {
+				gb.CPU.processOpcode()
+			}
+			received[i] = gb.Memory.Read(0xc100)
+		}(i, gb, link)
+	}
+	wg.Wait()
+	return received[0], received[1]
+}
+
+func TestLinkPipe(t *testing.T) {
+	a, b := LinkPipe()
+	if master, slave := runLinked(t, a, b); master != 0x99 || slave != 0x42 {
+		t.Errorf("Expected the bytes to be exchanged, got %#02x and %#02x", master, slave)
+	}
+}
+
+func TestLinkTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("No loopback:", err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, ok := <-accepted
+	if !ok {
+		t.Fatal("Could not accept the connection")
+	}
+
+	if master, slave := runLinked(t, NewLink(conn), NewLink(other)); master != 0x99 || slave != 0x42 {
+		t.Errorf("Expected the bytes to be exchanged, got %#02x and %#02x", master, slave)
+	}
+}
+
+// Once the other side is gone, the cable behaves as unplugged
+func TestLinkClosed(t *testing.T) {
+	a, b := LinkPipe()
+	rom := make([]byte, 0x8000)
+	gb, err := NewGameBoy(&rom, WithLink(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+	if cycles := transfer(gb, 0x42, 0x81); cycles >= 0x10000 {
+		t.Fatal("Expected the transfer to complete")
+	}
+	gb.Memory.Assert(0xff01, 0xff, t)
+	if a.Err() == nil {
+		t.Error("Expected the link to be disconnected")
+	}
+}
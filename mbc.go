@@ -4,6 +4,11 @@ package goboy
 type MBC interface {
 	read(address uint16) byte
 	write(address uint16, value byte)
+	// save returns the battery-backed state of the cartridge, the RAM
+	// followed by the RTC if any, or nil when it has no battery
+	save() []byte
+	// load restores a state returned by save
+	load(data []byte) error
 }
 
 // MBC0 emulates no MBC (32kB ROM only)
@@ -13,12 +18,41 @@ type MBC0 struct {
 
 // MBC1 emulates the Memory Bank Controller 1
 type MBC1 struct {
-	rom       []byte
-	ram       [0x8000]byte
-	ramMode   bool
-	writeable bool
-	romBank   uint8
-	ramBank   uint8
+	rom        []byte
+	ram        []byte
+	romBanks   int  // a power of two, the bank numbers are masked with it
+	multicart  bool // MBC1M wiring, only 4 bits of BANK1 are used
+	ramEnabled bool
+	battery    bool
+	bank1      byte // 5 bits, ROM bank number
+	bank2      byte // 2 bits, upper ROM bank bits or RAM bank
+	mode       bool // BANK2 also applies to 0x0000-0x3fff and to the RAM
+}
+
+// romBanks returns the number of 16kB ROM banks, rounded up to a power of two
+func romBanks(rom []byte) int {
+	banks := 2
+	for banks*0x4000 < len(rom) {
+		banks *= 2
+	}
+	return banks
+}
+
+// saveRAM returns a copy of the RAM of a cartridge with a battery
+func saveRAM(ram []byte, battery bool) []byte {
+	if !battery {
+		return nil
+	}
+	return append([]byte{}, ram...)
+}
+
+// loadRAM restores a saved RAM, it may be followed by other data
+func loadRAM(ram []byte, data []byte) error {
+	if len(data) < len(ram) {
+		return ErrInvalidSave
+	}
+	copy(ram, data)
+	return nil
 }
 
 // No MBC (or "MBC0")
@@ -33,62 +67,108 @@ func (m *MBC0) read(address uint16) byte {
 }
 
 func (m *MBC0) write(address uint16, value byte) {
-	panic("Attempt to write in ROM")
+	// Writes to the ROM are ignored
+}
+
+func (m *MBC0) save() []byte {
+	return nil
+}
+
+func (m *MBC0) load(data []byte) error {
+	return nil
 }
 
 // MBC1
 func loadMBC1(rom *[]byte) *MBC1 {
 	m := &MBC1{}
 	m.rom = *rom
-	m.romBank = 1
+	m.romBanks = romBanks(m.rom)
+	m.ram = make([]byte, ramSize(m.rom[0x149]))
+	m.bank1 = 1
+	m.battery = CartridgeType(m.rom[0x147]).Battery()
+	// Multicarts are 1MB ROMs with another game, thus another logo, in bank 0x10
+	if len(m.rom) == 0x100000 {
+		logo := m.rom[0x104:0x134]
+		m.multicart = string(logo) == string(m.rom[0x40104:0x40134])
+	}
 	return m
 }
 
+// bank returns the ROM bank mapped at an address
+func (m *MBC1) bank(address uint16) int {
+	shift := uint(5)
+	bank1 := int(m.bank1)
+	if m.multicart {
+		shift = 4
+		bank1 &= 0xf
+	}
+	bank := 0
+	if address >= 0x4000 {
+		bank = bank1
+	}
+	if address >= 0x4000 || m.mode {
+		bank |= int(m.bank2) << shift
+	}
+	return bank & (m.romBanks - 1)
+}
+
+// ramAddress returns the offset of an address in the RAM
+func (m *MBC1) ramAddress(address uint16) int {
+	offset := int(address - 0xa000)
+	if m.mode {
+		offset += int(m.bank2) * 0x2000
+	}
+	return offset & (len(m.ram) - 1)
+}
+
 func (m *MBC1) read(address uint16) byte {
 	switch {
-	case address < 0x4000: // ROM bank 0
-		return m.rom[address]
-	case 0x4000 <= address && address < 0x8000: // Other ROM bank
-		return m.rom[address+uint16(m.romBank-1)*0x4000]
+	case address < 0x8000: // ROM banks
+		offset := m.bank(address)*0x4000 + int(address&0x3fff)
+		if offset >= len(m.rom) {
+			return 0xff
+		}
+		return m.rom[offset]
 	case 0xa000 <= address && address < 0xc000: // RAM bank
-		return m.ram[address-0xa000+uint16(m.ramBank)*0x2000]
+		if !m.ramEnabled || len(m.ram) == 0 { // disabled RAM reads as an open bus
+			return 0xff
+		}
+		return m.ram[m.ramAddress(address)]
 	default:
-		panic("Invalid address to read")
+		return 0xff
 	}
 }
 
 func (m *MBC1) write(address uint16, value byte) {
 	switch {
-	// Writeable RAM mode
+	// Enable RAM
 	case address < 0x2000:
-		mode := value & 0xf
-		m.writeable = (mode == 10)
+		m.ramEnabled = value&0xf == 0xa
 	// Change ROM bank
 	case 0x2000 <= address && address < 0x4000:
 		bank := value & 0x1f // keep last five bits
 		if bank == 0 {       // special case
 			bank = 1
 		}
-		m.romBank = (m.romBank & 0x60) + bank
-	// Change RAM bank
+		m.bank1 = bank
+	// Change RAM bank, or upper bits of the ROM bank
 	case 0x4000 <= address && address < 0x6000:
-		bank := value & 0x3 // keep last two bits
-		if m.ramMode {
-			m.ramBank = bank
-		} else { // The most significant bits of the ROM bank have changed.
-			m.romBank = bank*0x20 + (m.romBank & 0x1f)
-		}
-	// Select RAM mode
+		m.bank2 = value & 0x3 // keep last two bits
+	// Select banking mode
 	case 0x6000 <= address && address < 0x8000:
-		m.ramMode = (value & 1) == 1
-	// Write in RAM
+		m.mode = (value & 1) == 1
+	// Write in RAM, ignored while it is disabled
 	case 0xa000 <= address && address < 0xc000:
-		if m.writeable {
-			m.ram[address-0xa000+uint16(m.ramBank)*0x2000] = value
-		} else {
-			panic("Tried to write in protected RAM")
+		if m.ramEnabled && len(m.ram) > 0 {
+			m.ram[m.ramAddress(address)] = value
 		}
-	default:
-		panic("Invalid address to write to")
 	}
 }
+
+func (m *MBC1) save() []byte {
+	return saveRAM(m.ram, m.battery)
+}
+
+func (m *MBC1) load(data []byte) error {
+	return loadRAM(m.ram, data)
+}
@@ -0,0 +1,74 @@
+package goboy
+
+// MBC2 emulates the Memory Bank Controller 2, which has 512x4 bits of RAM
+type MBC2 struct {
+	rom        []byte
+	ram        [0x200]byte // only the low nibbles are wired
+	romBanks   int
+	romBank    int
+	ramEnabled bool
+	battery    bool
+}
+
+func loadMBC2(rom *[]byte) *MBC2 {
+	m := &MBC2{}
+	m.rom = *rom
+	m.romBanks = romBanks(m.rom)
+	m.romBank = 1
+	m.battery = CartridgeType(m.rom[0x147]).Battery()
+	return m
+}
+
+func (m *MBC2) read(address uint16) byte {
+	switch {
+	case address < 0x8000: // ROM banks
+		bank := 0
+		if address >= 0x4000 {
+			bank = m.romBank
+		}
+		offset := bank*0x4000 + int(address&0x3fff)
+		if offset >= len(m.rom) {
+			return 0xff
+		}
+		return m.rom[offset]
+	case 0xa000 <= address && address < 0xc000: // RAM, echoed every 512 bytes
+		if !m.ramEnabled {
+			return 0xff
+		}
+		return 0xf0 | m.ram[address&0x1ff]
+	default:
+		return 0xff
+	}
+}
+
+func (m *MBC2) write(address uint16, value byte) {
+	switch {
+	// The bit 8 of the address selects the register
+	case address < 0x4000 && address&0x100 == 0:
+		m.ramEnabled = value&0xf == 0xa
+	case address < 0x4000:
+		bank := int(value & 0xf)
+		if bank == 0 {
+			bank = 1
+		}
+		m.romBank = bank & (m.romBanks - 1)
+	case 0xa000 <= address && address < 0xc000:
+		if m.ramEnabled {
+			m.ram[address&0x1ff] = value & 0xf
+		}
+	}
+}
+
+func (m *MBC2) save() []byte {
+	return saveRAM(m.ram[:], m.battery)
+}
+
+func (m *MBC2) load(data []byte) error {
+	if err := loadRAM(m.ram[:], data); err != nil {
+		return err
+	}
+	for i := range m.ram {
+		m.ram[i] &= 0xf
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package goboy
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// TimeSource gives the current time to the real-time clocks of the cartridges
+type TimeSource interface {
+	Now() time.Time
+}
+
+type systemTime struct{}
+
+func (systemTime) Now() time.Time {
+	return time.Now()
+}
+
+// RTC registers, as selected by the RAM bank number
+const (
+	rtcSeconds = 0x08 + iota
+	rtcMinutes
+	rtcHours
+	rtcDaysLow
+	rtcDaysHigh
+)
+
+const (
+	secondsPerDay = 24 * 60 * 60
+	rtcMaxDays    = 512 // the day counter is 9 bits wide
+)
+
+// Sizes of the RTC footer of the saves, as written by VBA-M, BGB and
+// others: the current then the latched registers as 32-bit little endian
+// words, followed by a UNIX timestamp on 64 bits (or 32 bits in older saves)
+const (
+	rtcFooterSize      = 48
+	rtcShortFooterSize = 44
+)
+
+// rtc is the real-time clock of the MBC3, it counts seconds
+// since its day 0 as long as it is not halted
+type rtc struct {
+	source  TimeSource
+	seconds int64     // value of the counter at the last update
+	updated time.Time // when the counter was last updated
+	halted  bool
+	carry   bool // the day counter overflowed
+	latched [5]byte
+	latch   byte // last value written to the latch register
+}
+
+func newRTC(source TimeSource) *rtc {
+	return &rtc{source: source, updated: source.Now()}
+}
+
+// update counts the seconds elapsed since the last update
+func (r *rtc) update() {
+	now := r.source.Now()
+	if r.halted {
+		r.updated = now
+		return
+	}
+	elapsed := int64(now.Sub(r.updated) / time.Second)
+	r.seconds += elapsed
+	r.updated = r.updated.Add(time.Duration(elapsed) * time.Second)
+	if r.seconds >= rtcMaxDays*secondsPerDay {
+		r.seconds %= rtcMaxDays * secondsPerDay
+		r.carry = true
+	}
+}
+
+// setSource changes the time source, the counter keeps its value
+func (r *rtc) setSource(source TimeSource) {
+	r.update()
+	r.source = source
+	r.updated = source.Now()
+}
+
+// registers returns the current values of the RTC registers
+func (r *rtc) registers() [5]byte {
+	r.update()
+	days := r.seconds / secondsPerDay
+	dh := byte(days>>8) & 1
+	if r.halted {
+		dh |= 0x40
+	}
+	if r.carry {
+		dh |= 0x80
+	}
+	return [5]byte{
+		byte(r.seconds % 60),
+		byte(r.seconds / 60 % 60),
+		byte(r.seconds / 3600 % 24),
+		byte(days),
+		dh,
+	}
+}
+
+// writeLatch latches the registers when 0x00 then 0x01 are written
+func (r *rtc) writeLatch(value byte) {
+	if r.latch == 0 && value == 1 {
+		r.latched = r.registers()
+	}
+	r.latch = value
+}
+
+// setRegisters sets the counter from the values of the registers
+func (r *rtc) setRegisters(regs [5]byte) {
+	days := int64(regs[4]&1)<<8 | int64(regs[3])
+	r.seconds = days*secondsPerDay + int64(regs[2])*3600 + int64(regs[1])*60 + int64(regs[0])
+	r.halted = regs[4]&0x40 != 0
+	r.carry = regs[4]&0x80 != 0
+}
+
+// footer returns the state of the RTC in the format of the saves
+func (r *rtc) footer() []byte {
+	data := make([]byte, rtcFooterSize)
+	regs := r.registers()
+	for i := range regs {
+		binary.LittleEndian.PutUint32(data[i*4:], uint32(regs[i]))
+		binary.LittleEndian.PutUint32(data[20+i*4:], uint32(r.latched[i]))
+	}
+	binary.LittleEndian.PutUint64(data[40:], uint64(r.updated.Unix()))
+	return data
+}
+
+// loadFooter restores the state of the RTC from a save,
+// the time elapsed since the save is counted
+func (r *rtc) loadFooter(data []byte) error {
+	var saved int64
+	switch len(data) {
+	case rtcFooterSize:
+		saved = int64(binary.LittleEndian.Uint64(data[40:]))
+	case rtcShortFooterSize:
+		saved = int64(binary.LittleEndian.Uint32(data[40:]))
+	default:
+		return ErrInvalidSave
+	}
+	var regs [5]byte
+	for i := range regs {
+		regs[i] = byte(binary.LittleEndian.Uint32(data[i*4:]))
+		r.latched[i] = byte(binary.LittleEndian.Uint32(data[20+i*4:]))
+	}
+	r.setRegisters(regs)
+	r.updated = time.Unix(saved, 0)
+	if now := r.source.Now(); r.updated.After(now) { // the host clock went back
+		r.updated = now
+	}
+	r.update()
+	return nil
+}
+
+func (r *rtc) read(register byte) byte {
+	return r.latched[register-rtcSeconds]
+}
+
+func (r *rtc) write(register byte, value byte) {
+	regs := r.registers()
+	switch register {
+	case rtcSeconds:
+		regs[0] = value % 60
+		r.updated = r.source.Now() // the sub-second divider is reset too
+	case rtcMinutes:
+		regs[1] = value % 60
+	case rtcHours:
+		regs[2] = value % 24
+	case rtcDaysLow:
+		regs[3] = value
+	case rtcDaysHigh:
+		regs[4] = value & 0xc1
+	}
+	r.setRegisters(regs)
+}
+
+// MBC3 emulates the Memory Bank Controller 3, some have a real-time clock
+type MBC3 struct {
+	rom        []byte
+	ram        []byte
+	romBanks   int
+	romBank    int
+	ramBank    byte // RAM bank (0-3) or RTC register (0x08-0x0c)
+	ramEnabled bool // enables the RTC too
+	battery    bool
+	rtc        *rtc
+}
+
+func loadMBC3(rom *[]byte) *MBC3 {
+	m := &MBC3{}
+	m.rom = *rom
+	m.romBanks = romBanks(m.rom)
+	m.romBank = 1
+	m.ram = make([]byte, ramSize(m.rom[0x149]))
+	cartridge := CartridgeType(m.rom[0x147])
+	m.battery = cartridge.Battery()
+	if cartridge.Timer() {
+		m.rtc = newRTC(systemTime{})
+	}
+	return m
+}
+
+func (m *MBC3) read(address uint16) byte {
+	switch {
+	case address < 0x8000: // ROM banks
+		bank := 0
+		if address >= 0x4000 {
+			bank = m.romBank
+		}
+		offset := bank*0x4000 + int(address&0x3fff)
+		if offset >= len(m.rom) {
+			return 0xff
+		}
+		return m.rom[offset]
+	case 0xa000 <= address && address < 0xc000: // RAM bank or RTC register
+		switch {
+		case !m.ramEnabled:
+			return 0xff
+		case m.ramBank >= rtcSeconds && m.ramBank <= rtcDaysHigh && m.rtc != nil:
+			return m.rtc.read(m.ramBank)
+		case m.ramBank < 4 && len(m.ram) > 0:
+			return m.ram[m.ramAddress(address)]
+		}
+	}
+	return 0xff
+}
+
+func (m *MBC3) ramAddress(address uint16) int {
+	return (int(m.ramBank)*0x2000 + int(address-0xa000)) & (len(m.ram) - 1)
+}
+
+func (m *MBC3) write(address uint16, value byte) {
+	switch {
+	// Enable RAM and RTC
+	case address < 0x2000:
+		m.ramEnabled = value&0xf == 0xa
+	// Change ROM bank
+	case 0x2000 <= address && address < 0x4000:
+		bank := int(value & 0x7f)
+		if bank == 0 {
+			bank = 1
+		}
+		m.romBank = bank & (m.romBanks - 1)
+	// Change RAM bank or select a RTC register
+	case 0x4000 <= address && address < 0x6000:
+		m.ramBank = value
+	// Latch the RTC registers
+	case 0x6000 <= address && address < 0x8000:
+		if m.rtc != nil {
+			m.rtc.writeLatch(value)
+		}
+	case 0xa000 <= address && address < 0xc000:
+		switch {
+		case !m.ramEnabled:
+		case m.ramBank >= rtcSeconds && m.ramBank <= rtcDaysHigh && m.rtc != nil:
+			m.rtc.write(m.ramBank, value)
+		case m.ramBank < 4 && len(m.ram) > 0:
+			m.ram[m.ramAddress(address)] = value
+		}
+	}
+}
+
+func (m *MBC3) save() []byte {
+	data := saveRAM(m.ram, m.battery)
+	if m.rtc != nil {
+		data = append(data, m.rtc.footer()...)
+	}
+	return data
+}
+
+func (m *MBC3) load(data []byte) error {
+	if err := loadRAM(m.ram, data); err != nil {
+		return err
+	}
+	// Saves without the RTC footer are accepted, the clock keeps running
+	if footer := data[len(m.ram):]; m.rtc != nil && len(footer) > 0 {
+		return m.rtc.loadFooter(footer)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package goboy
+
+// MBC5 emulates the Memory Bank Controller 5, some have a rumble motor
+type MBC5 struct {
+	rom        []byte
+	ram        []byte
+	romBanks   int
+	romBank    int // 9 bits, bank 0 can be mapped at 0x4000
+	ramBank    byte
+	ramEnabled bool
+	battery    bool
+	rumble     bool       // the bit 3 of the RAM bank drives a motor
+	motor      bool       // the motor is on
+	onRumble   func(bool) // called when the motor is turned on or off
+}
+
+func loadMBC5(rom *[]byte) *MBC5 {
+	m := &MBC5{}
+	m.rom = *rom
+	m.romBanks = romBanks(m.rom)
+	m.romBank = 1
+	m.ram = make([]byte, ramSize(m.rom[0x149]))
+	cartridge := CartridgeType(m.rom[0x147])
+	m.battery = cartridge.Battery()
+	m.rumble = cartridge.Rumble()
+	return m
+}
+
+func (m *MBC5) read(address uint16) byte {
+	switch {
+	case address < 0x8000: // ROM banks
+		bank := 0
+		if address >= 0x4000 {
+			bank = m.romBank & (m.romBanks - 1)
+		}
+		offset := bank*0x4000 + int(address&0x3fff)
+		if offset >= len(m.rom) {
+			return 0xff
+		}
+		return m.rom[offset]
+	case 0xa000 <= address && address < 0xc000: // RAM bank
+		if !m.ramEnabled || len(m.ram) == 0 {
+			return 0xff
+		}
+		return m.ram[m.ramAddress(address)]
+	default:
+		return 0xff
+	}
+}
+
+func (m *MBC5) ramAddress(address uint16) int {
+	return (int(m.ramBank)*0x2000 + int(address-0xa000)) & (len(m.ram) - 1)
+}
+
+func (m *MBC5) write(address uint16, value byte) {
+	switch {
+	// Enable RAM, MBC5 compares all the bits
+	case address < 0x2000:
+		m.ramEnabled = value == 0x0a
+	// Low 8 bits of the ROM bank
+	case 0x2000 <= address && address < 0x3000:
+		m.romBank = m.romBank&0x100 | int(value)
+	// 9th bit of the ROM bank
+	case 0x3000 <= address && address < 0x4000:
+		m.romBank = m.romBank&0xff | int(value&1)<<8
+	// Change RAM bank, on rumble carts the bit 3 drives the motor
+	case 0x4000 <= address && address < 0x6000:
+		if m.rumble {
+			m.setMotor(value&0x08 != 0)
+			value &= 0x07
+		}
+		m.ramBank = value & 0x0f
+	case 0xa000 <= address && address < 0xc000:
+		if m.ramEnabled && len(m.ram) > 0 {
+			m.ram[m.ramAddress(address)] = value
+		}
+	}
+}
+
+func (m *MBC5) setMotor(on bool) {
+	if on != m.motor {
+		m.motor = on
+		if m.onRumble != nil {
+			m.onRumble(on)
+		}
+	}
+}
+
+func (m *MBC5) save() []byte {
+	return saveRAM(m.ram, m.battery)
+}
+
+func (m *MBC5) load(data []byte) error {
+	return loadRAM(m.ram, data)
+}
@@ -0,0 +1,281 @@
+package goboy
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// bankedROM returns a ROM where every byte holds the number of its bank
+func bankedROM(size int, cartridge, ram byte) []byte {
+	rom := make([]byte, size)
+	for i := range rom {
+		rom[i] = byte(i / 0x4000)
+	}
+	rom[0x147] = cartridge
+	rom[0x149] = ram
+	return rom
+}
+
+func assertRead(m MBC, address uint16, value byte, t *testing.T) {
+	t.Helper()
+	if result := m.read(address); result != value {
+		t.Errorf("Expected %#02x at %#04x, got %#02x", value, address, result)
+	}
+}
+
+func TestMBC1LargeROM(t *testing.T) {
+	rom := bankedROM(0x200000, 1, 0) // 2MB, 128 banks
+	m := loadMBC1(&rom)
+	m.write(0x2000, 0x05)
+	m.write(0x4000, 0x03)
+	assertRead(m, 0x4000, 0x65, t)
+	assertRead(m, 0x0000, 0x00, t)
+	m.write(0x6000, 1) // mode 1 maps bank 0x60 in the first area
+	assertRead(m, 0x0000, 0x60, t)
+	m.write(0x2000, 0x00) // bank 0 is mapped as bank 1
+	assertRead(m, 0x4000, 0x61, t)
+}
+
+func TestMBC1ROMMask(t *testing.T) {
+	rom := bankedROM(0x40000, 1, 0) // 256kB, 16 banks
+	m := loadMBC1(&rom)
+	m.write(0x2000, 0x15)
+	assertRead(m, 0x4000, 0x05, t)
+	m.write(0x4000, 0x01)
+	assertRead(m, 0x7fff, 0x05, t)
+}
+
+func TestMBC1RAM(t *testing.T) {
+	rom := bankedROM(0x8000, 3, 3) // 32kB of RAM
+	m := loadMBC1(&rom)
+	m.write(0xa000, 42)
+	assertRead(m, 0xa000, 0xff, t) // disabled
+	m.write(0x0000, 0x0a)
+	m.write(0x6000, 1)
+	m.write(0x4000, 2)
+	m.write(0xa000, 42)
+	assertRead(m, 0xa000, 42, t)
+	m.write(0x4000, 0)
+	assertRead(m, 0xa000, 0, t)
+	if len(m.ram) != 0x8000 || m.ram[0x4000] != 42 {
+		t.Error("Expected the write in RAM bank 2")
+	}
+
+	rom = bankedROM(0x8000, 3, 2) // 8kB of RAM, the banks are mirrors
+	m = loadMBC1(&rom)
+	m.write(0x0000, 0x0a)
+	m.write(0x6000, 1)
+	m.write(0xa000, 42)
+	m.write(0x4000, 3)
+	assertRead(m, 0xa000, 42, t)
+}
+
+func TestMBC1Multicart(t *testing.T) {
+	rom := bankedROM(0x100000, 1, 0)
+	logo := bytes.Repeat([]byte{0xce}, 0x30) // any logo, as long as both games have it
+	copy(rom[0x104:], logo)
+	copy(rom[0x40104:], logo)
+	m := loadMBC1(&rom)
+	if !m.multicart {
+		t.Fatal("Expected a multicart")
+	}
+	m.write(0x2000, 0x12) // only 4 bits are wired
+	m.write(0x4000, 0x01)
+	assertRead(m, 0x4000, 0x12, t)
+	m.write(0x6000, 1)
+	assertRead(m, 0x0000, 0x10, t)
+}
+
+func TestMBC2(t *testing.T) {
+	rom := bankedROM(0x40000, 6, 0)
+	m := loadMBC2(&rom)
+	if !m.battery {
+		t.Error("Expected a battery")
+	}
+	m.write(0x2100, 0x13) // bit 8 set: ROM bank, 4 bits
+	assertRead(m, 0x4000, 0x03, t)
+	m.write(0x2000, 0x0a) // bit 8 clear: RAM enable
+	assertRead(m, 0x4000, 0x03, t)
+	m.write(0xa000, 0x5a)
+	assertRead(m, 0xa000, 0xfa, t) // the upper nibble reads as 1s
+	assertRead(m, 0xa200, 0xfa, t)
+	assertRead(m, 0xbe00, 0xfa, t)
+	m.write(0x0000, 0x00)
+	assertRead(m, 0xa000, 0xff, t)
+}
+
+// fakeTime is a time source that only moves forward when told to
+type fakeTime struct {
+	now time.Time
+}
+
+func (f *fakeTime) Now() time.Time {
+	return f.now
+}
+
+func (f *fakeTime) forward(d time.Duration) {
+	f.now = f.now.Add(d)
+}
+
+// readRTC latches the RTC and reads a register
+func readRTC(m *MBC3, register byte) byte {
+	m.write(0x6000, 0)
+	m.write(0x6000, 1)
+	m.write(0x4000, register)
+	return m.read(0xa000)
+}
+
+func TestMBC3Banks(t *testing.T) {
+	rom := bankedROM(0x200000, 0x13, 3)
+	m := loadMBC3(&rom)
+	m.write(0x2000, 0x7f)
+	assertRead(m, 0x4000, 0x7f, t)
+	m.write(0x0000, 0x0a)
+	m.write(0x4000, 3)
+	m.write(0xa000, 42)
+	m.write(0x4000, 0)
+	assertRead(m, 0xa000, 0, t)
+	m.write(0x4000, 3)
+	assertRead(m, 0xa000, 42, t)
+}
+
+func TestMBC3RTC(t *testing.T) {
+	rom := bankedROM(0x8000, 0x10, 3)
+	m := loadMBC3(&rom)
+	source := &fakeTime{time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m.rtc.setSource(source)
+	m.write(0x0000, 0x0a)
+
+	source.forward(26*time.Hour + 3*time.Minute + 4*time.Second + 500*time.Millisecond)
+	s, mn := readRTC(m, rtcSeconds), readRTC(m, rtcMinutes)
+	h, d := readRTC(m, rtcHours), readRTC(m, rtcDaysLow)
+	if s != 4 || mn != 3 || h != 2 || d != 1 {
+		t.Errorf("Expected day 1 02:03:04, got day %d %02d:%02d:%02d", d, h, mn, s)
+	}
+
+	// The registers only change when latched
+	m.write(0x4000, rtcSeconds)
+	source.forward(time.Second)
+	assertRead(m, 0xa000, 4, t)
+	m.write(0x6000, 0)
+	m.write(0x6000, 1)
+	assertRead(m, 0xa000, 5, t)
+
+	// Halted, the clock does not count
+	m.write(0x4000, rtcDaysHigh)
+	m.write(0xa000, 0x40)
+	source.forward(time.Hour)
+	if h := readRTC(m, rtcHours); h != 2 {
+		t.Error("Expected the halted clock to stay at 2h, got", h)
+	}
+	m.write(0x4000, rtcDaysHigh)
+	m.write(0xa000, 0x00)
+
+	// Day 511 overflows to 0 with the carry bit set
+	m.write(0x4000, rtcDaysLow)
+	m.write(0xa000, 0xff)
+	m.write(0x4000, rtcDaysHigh)
+	m.write(0xa000, 0x01)
+	source.forward(24 * time.Hour)
+	if d, dh := readRTC(m, rtcDaysLow), readRTC(m, rtcDaysHigh); d != 0 || dh != 0x80 {
+		t.Errorf("Expected day 0 with the carry, got day %d DH=%#02x", d, dh)
+	}
+}
+
+func TestMBC5(t *testing.T) {
+	rom := bankedROM(0x800000, 0x1b, 4) // 8MB, 512 banks
+	m := loadMBC5(&rom)
+	m.write(0x2000, 0x00) // bank 0 can be mapped
+	assertRead(m, 0x4000, 0x00, t)
+	m.write(0x2000, 0x2a)
+	m.write(0x3000, 0x01)
+	if m.read(0x4000) != 0x2a || m.romBank != 0x12a {
+		t.Error("Expected bank 0x12a, got", m.romBank)
+	}
+	m.write(0x0000, 0x0a)
+	m.write(0x4000, 0x0f)
+	m.write(0xa000, 42)
+	if m.ram[0xf*0x2000] != 42 {
+		t.Error("Expected the write in RAM bank 15")
+	}
+}
+
+func TestMBC5Rumble(t *testing.T) {
+	rom := bankedROM(0x8000, 0x1e, 3)
+	var events []bool
+	m := loadMBC5(&rom)
+	m.onRumble = func(on bool) { events = append(events, on) }
+	m.write(0x0000, 0x0a)
+	m.write(0x4000, 0x0b) // motor on, RAM bank 3
+	m.write(0xa000, 42)
+	m.write(0x4000, 0x0b)
+	m.write(0x4000, 0x03)
+	if len(events) != 2 || !events[0] || events[1] {
+		t.Error("Expected the motor to be turned on then off, got", events)
+	}
+	assertRead(m, 0xa000, 42, t)
+}
+
+func TestMBCSave(t *testing.T) {
+	rom := bankedROM(0x8000, 0x02, 2) // no battery
+	if data := loadMBC1(&rom).save(); data != nil {
+		t.Error("Expected no save without a battery, got", len(data), "bytes")
+	}
+
+	rom = bankedROM(0x8000, 0x06, 0)
+	m := loadMBC2(&rom)
+	m.write(0x0000, 0x0a)
+	m.write(0xa001, 0x05)
+	saved := m.save()
+	if len(saved) != 0x200 || saved[1] != 0x05 {
+		t.Error("Expected the 512 nibbles of the MBC2 RAM")
+	}
+	m = loadMBC2(&rom)
+	m.write(0x0000, 0x0a)
+	if err := m.load(saved); err != nil {
+		t.Fatal(err)
+	}
+	assertRead(m, 0xa001, 0xf5, t)
+	if err := m.load(saved[:0x100]); err != ErrInvalidSave {
+		t.Error("Expected a short save to be refused, got", err)
+	}
+}
+
+func TestMBC3SaveRTC(t *testing.T) {
+	rom := bankedROM(0x8000, 0x10, 2)
+	source := &fakeTime{time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m := loadMBC3(&rom)
+	m.rtc.setSource(source)
+	m.write(0x0000, 0x0a)
+	m.write(0x4000, 0x00)
+	m.write(0xa000, 42)
+	source.forward(90 * time.Minute)
+
+	saved := m.save()
+	if len(saved) != 0x2000+rtcFooterSize {
+		t.Fatal("Expected the RAM and a 48 bytes footer, got", len(saved))
+	}
+	if saved[0x2000+8] != 1 || saved[0x2000+4] != 30 {
+		t.Error("Expected 01:30:00 in the footer")
+	}
+
+	// The clock counts the time elapsed since the save
+	source.forward(3 * time.Hour)
+	m = loadMBC3(&rom)
+	m.rtc.setSource(source)
+	if err := m.load(saved); err != nil {
+		t.Fatal(err)
+	}
+	m.write(0x0000, 0x0a)
+	if h, mn := readRTC(m, rtcHours), readRTC(m, rtcMinutes); h != 4 || mn != 30 {
+		t.Errorf("Expected 04:30, got %02d:%02d", h, mn)
+	}
+	m.write(0x4000, 0x00)
+	assertRead(m, 0xa000, 42, t)
+
+	// The older footer has a 32-bit timestamp
+	if err := m.load(saved[:0x2000+rtcShortFooterSize]); err != nil {
+		t.Error("Expected the 44 bytes footer to be accepted, got", err)
+	}
+}
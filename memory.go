@@ -1,70 +1,208 @@
 package goboy
 
+import "fmt"
+
 // memory represents the address space the CPU/PPU can use to access data
 type memory struct {
-	mem          [0xffff]byte // TODO: optimize space, just because we could
-	mbc          MBC
-	bootDisabled bool
+	mem    [0x10000]byte // TODO: optimize space, just because we could
+	wram   [0x8000]byte  // 8 banks of 4kB, 1-7 are switched at 0xd000 on CGB
+	svbk   byte          // WRAM bank, 0 selects the bank 1
+	header Header
+	mbc    MBC
+	irq    *interrupts
+	timer  *timer
+	ppu    *ppu
+	apu    *apu
+	joypad *joypad
+	serial *serial
+	speed  *speed // KEY1, only mapped in CGB mode
+	dma    oamDMA
+	hdma   hdma
+	cgb    bool
+	boot   []byte // the boot ROM, nil once unmapped by FF50
 }
 
-var bootROM = [256]byte{
-	0x31, 0xfe, 0xff, 0xaf, 0x21, 0xff, 0x9f, 0x32, 0xcb, 0x7c, 0x20, 0xfb, 0x21, 0x26, 0xff, 0x0e,
-	0x11, 0x3e, 0x80, 0x32, 0xe2, 0x0c, 0x3e, 0xf3, 0xe2, 0x32, 0x3e, 0x77, 0x77, 0x3e, 0xfc, 0xe0,
-	0x47, 0x11, 0x04, 0x01, 0x21, 0x10, 0x80, 0x1a, 0xcd, 0x95, 0x00, 0xcd, 0x96, 0x00, 0x13, 0x7b,
-	0xfe, 0x34, 0x20, 0xf3, 0x11, 0xd8, 0x00, 0x06, 0x08, 0x1a, 0x13, 0x22, 0x23, 0x05, 0x20, 0xf9,
-	0x3e, 0x19, 0xea, 0x10, 0x99, 0x21, 0x2f, 0x99, 0x0e, 0x0c, 0x3d, 0x28, 0x08, 0x32, 0x0d, 0x20,
-	0xf9, 0x2e, 0x0f, 0x18, 0xf3, 0x67, 0x3e, 0x64, 0x57, 0xe0, 0x42, 0x3e, 0x91, 0xe0, 0x40, 0x04,
-	0x1e, 0x02, 0x0e, 0x0c, 0xf0, 0x44, 0xfe, 0x90, 0x20, 0xfa, 0x0d, 0x20, 0xf7, 0x1d, 0x20, 0xf2,
-	0x0e, 0x13, 0x24, 0x7c, 0x1e, 0x83, 0xfe, 0x62, 0x28, 0x06, 0x1e, 0xc1, 0xfe, 0x64, 0x20, 0x06,
-	0x7b, 0xe2, 0x0c, 0x3e, 0x87, 0xe2, 0xf0, 0x42, 0x90, 0xe0, 0x42, 0x15, 0x20, 0xd2, 0x05, 0x20,
-	0x4f, 0x16, 0x20, 0x18, 0xcb, 0x4f, 0x06, 0x04, 0xc5, 0xcb, 0x11, 0x17, 0xc1, 0xcb, 0x11, 0x17,
-	0x05, 0x20, 0xf5, 0x22, 0x23, 0x22, 0x23, 0xc9, 0xce, 0xed, 0x66, 0x66, 0xcc, 0x0d, 0x00, 0x0b,
-	0x03, 0x73, 0x00, 0x83, 0x00, 0x0c, 0x00, 0x0d, 0x00, 0x08, 0x11, 0x1f, 0x88, 0x89, 0x00, 0x0e,
-	0xdc, 0xcc, 0x6e, 0xe6, 0xdd, 0xdd, 0xd9, 0x99, 0xbb, 0xbb, 0x67, 0x63, 0x6e, 0x0e, 0xec, 0xcc,
-	0xdd, 0xdc, 0x99, 0x9f, 0xbb, 0xb9, 0x33, 0x3e, 0x3c, 0x42, 0xb9, 0xa5, 0xb9, 0xa5, 0x42, 0x3c,
-	0x21, 0x04, 0x01, 0x11, 0xa8, 0x00, 0x1a, 0x13, 0xbe, 0x20, 0xfe, 0x23, 0x7d, 0xfe, 0x34, 0x20,
-	0xf5, 0x06, 0x19, 0x78, 0x86, 0x23, 0x05, 0x20, 0xfb, 0x86, 0x20, 0xfe, 0x3e, 0x01, 0xe0, 0x50}
-
 // loadRom loads the content of a cartdridge in memory
-func (m *memory) loadRom(rom *[]byte) {
-	switch (*rom)[0x147] {
-	case 0:
+func (m *memory) loadRom(rom *[]byte) error {
+	header, err := ParseHeader(*rom)
+	if err != nil {
+		return err
+	}
+	m.header = header
+	switch header.Cartridge.controller() {
+	case noController:
 		m.mbc = loadMBC0(rom)
-	case 1:
+	case controllerMBC1:
 		m.mbc = loadMBC1(rom)
+	case controllerMBC2:
+		m.mbc = loadMBC2(rom)
+	case controllerMBC3:
+		m.mbc = loadMBC3(rom)
+	case controllerMBC5:
+		m.mbc = loadMBC5(rom)
+	default:
+		return fmt.Errorf("%w %#02x", ErrUnsupportedCartridge, byte(header.Cartridge))
+	}
+	return nil
+}
+
+// wramAddress returns the offset in the WRAM of an address of 0xc000-0xfdff,
+// 0xe000-0xfdff echoes 0xc000-0xddff with the same bank
+func (m *memory) wramAddress(address uint16) int {
+	offset := int(address-0xc000) & 0x1fff
+	if offset >= 0x1000 {
+		bank := int(m.svbk & 7)
+		if bank == 0 {
+			bank = 1
+		}
+		offset += (bank - 1) * 0x1000
+	}
+	return offset
+}
+
+// readCGB reads the registers only mapped in CGB mode
+func (m *memory) readCGB(address uint16) byte {
+	if !m.cgb {
+		return 0xff
+	}
+	switch address {
+	case 0xff4d: // KEY1
+		return m.speed.read()
+	case 0xff70: // SVBK
+		return 0xf8 | m.svbk
+	case 0xff51, 0xff52, 0xff53, 0xff54, 0xff55:
+		return m.hdma.read(address)
 	default:
-		panic("Unknown cartridge type")
+		return 0xff
 	}
 }
 
+// setCompatibility leaves the CGB mode for the DMG compatibility mode,
+// the colors still come from the palettes set by the boot ROM
+func (m *memory) setCompatibility() {
+	m.cgb = false
+	m.ppu.cgb = false
+	m.serial.cgb = false
+	m.ppu.compatibility = true
+}
+
+func (m *memory) writeCGB(address uint16, value byte) {
+	if !m.cgb {
+		return
+	}
+	switch address {
+	case 0xff4c: // KEY0, written by the boot ROM to run the DMG games
+		if m.boot != nil && value&0x04 != 0 {
+			m.setCompatibility()
+		}
+	case 0xff4d: // KEY1
+		m.speed.write(value)
+	case 0xff70: // SVBK
+		m.svbk = value & 7
+	case 0xff51, 0xff52, 0xff53, 0xff54, 0xff55:
+		m.hdma.write(address, value)
+	}
+}
+
+// Read is an access of the CPU, the OAM DMA leaves it only the HRAM
 func (m *memory) Read(address uint16) byte {
+	if m.dma.blocks(address) {
+		return 0xff
+	}
+	return m.read(address)
+}
+
+func (m *memory) Write(address uint16, value byte) {
+	if !m.dma.blocks(address) {
+		m.write(address, value)
+	}
+}
+
+func (m *memory) read(address uint16) byte {
 	switch {
+	case 0xc000 <= address && address < 0xfe00: // 8kB Internal RAM and its echo
+		return m.wram[m.wramAddress(address)]
+	case 0x8000 <= address && address < 0xa000: // 8kB Video RAM
+		return m.ppu.readVRAM(address)
 	case 0xa000 <= address && address < 0xc000: // 8kB Switchable RAM bank
 		return m.mbc.read(address)
-	case 0x100 <= address && address < 0x8000: // 32kB Cartridge
-		return m.mbc.read(address)
-	case address < 0x100: // 32kB Cartridge (boot sector)
-		if !m.bootDisabled {
-			return bootROM[address]
+	case address < 0x8000: // 32kB Cartridge, partly hidden by the boot ROM
+		if m.bootMapped(address) {
+			return m.boot[address]
 		}
 		return m.mbc.read(address)
+	case 0xfe00 <= address && address < 0xfea0: // Sprite attributes
+		return m.ppu.readOAM(address)
+	case 0xfea0 <= address && address < 0xff00: // Unusable
+		return 0
+	case address == 0xff00: // P1
+		return m.joypad.read()
+	case address == 0xff01 || address == 0xff02: // Serial
+		return m.serial.read(address)
+	case 0xff04 <= address && address < 0xff08: // Timer
+		return m.timer.read(address)
+	case 0xff10 <= address && address < 0xff40: // Sound
+		return m.apu.read(address)
+	case 0xff40 <= address && address < 0xff4c && address != 0xff46: // LCD
+		return m.ppu.read(address)
+	case address == 0xff4f || 0xff68 <= address && address < 0xff6c: // CGB LCD
+		return m.ppu.read(address)
+	case address == 0xff4c || address == 0xff4d || address == 0xff70: // KEY0, KEY1, SVBK
+		return m.readCGB(address)
+	case address == 0xff46: // OAM DMA
+		return m.dma.register
+	case address == 0xff50: // Boot ROM unmapping, write only
+		return 0xff
+	case 0xff51 <= address && address < 0xff56: // HDMA
+		return m.readCGB(address)
+	case address == 0xff0f: // IF
+		return m.irq.readFlags()
+	case address == 0xffff: // IE
+		return m.irq.enable
 	default:
 		return m.mem[address]
 	}
 }
 
-func (m *memory) Write(address uint16, value byte) {
+func (m *memory) write(address uint16, value byte) {
 	switch {
-	case 0xe000 <= address && address < 0xfe00: // Echo of 8kB Internal RAM
-		m.mem[address] = value
-		m.mem[address-0x2000] = value
-	case 0xc000 <= address && address < 0xe000: // 8kB Internal RAM
-		m.mem[address] = value
-		m.mem[address+0x2000] = value
+	case 0xc000 <= address && address < 0xfe00: // 8kB Internal RAM and its echo
+		m.wram[m.wramAddress(address)] = value
 	case 0xa000 <= address && address < 0xc000: // 8kB Switchable RAM bank
 		fallthrough
 	case address < 0x8000: // 32kB Cartridge
 		m.mbc.write(address, value)
+	case 0x8000 <= address && address < 0xa000: // 8kB Video RAM
+		m.ppu.writeVRAM(address, value)
+	case 0xfe00 <= address && address < 0xfea0: // Sprite attributes
+		m.ppu.writeOAM(address, value)
+	case 0xfea0 <= address && address < 0xff00: // Unusable
+	case address == 0xff00: // P1
+		m.joypad.write(value)
+	case address == 0xff01 || address == 0xff02: // Serial
+		m.serial.write(address, value)
+	case 0xff04 <= address && address < 0xff08: // Timer
+		m.timer.write(address, value)
+	case 0xff10 <= address && address < 0xff40: // Sound
+		m.apu.write(address, value)
+	case 0xff40 <= address && address < 0xff4c && address != 0xff46: // LCD
+		m.ppu.write(address, value)
+	case address == 0xff4f || 0xff68 <= address && address < 0xff6c: // CGB LCD
+		m.ppu.write(address, value)
+	case address == 0xff4c || address == 0xff4d || address == 0xff70: // KEY0, KEY1, SVBK
+		m.writeCGB(address, value)
+	case address == 0xff46: // OAM DMA
+		m.dma.write(value)
+	case address == 0xff50: // Unmaps the boot ROM, until the next reset
+		if value&1 != 0 {
+			m.boot = nil
+		}
+	case 0xff51 <= address && address < 0xff56: // HDMA
+		m.writeCGB(address, value)
+	case address == 0xff0f: // IF
+		m.irq.writeFlags(value)
+	case address == 0xffff: // IE
+		m.irq.enable = value
 	default:
 		m.mem[address] = value
 	}
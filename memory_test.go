@@ -27,7 +27,6 @@ func TestRomLoading(t *testing.T) {
 		data[i] = byte(i/1000 + 1)
 	}
 	m.loadRom(&data)
-	m.bootDisabled = true
 	m.Assert(0, 1, t)
 	m.Assert(0x3fff, 17, t)
 	m.Assert(0x4000, 17, t)
@@ -53,9 +52,18 @@ func TestMBC1ChangeROM(t *testing.T) {
 	}
 	data[0x147] = 1 // MBC1
 	m.loadRom(&data)
-	m.bootDisabled = true
 	m.Assert(0, 1, t)
 	m.Assert(0x4000, 17, t)
 	m.Write(0x2000, 3) // Change to ROM bank 3
 	m.Assert(0x4000, 49, t)
 }
+
+// Games write to the ROM to control the MBC, without MBC it does nothing
+func TestROMWriteIgnored(t *testing.T) {
+	m := memory{}
+	data := make([]byte, 0x8000)
+	data[0x2000] = 42
+	m.loadRom(&data)
+	m.Write(0x2000, 1)
+	m.Assert(0x2000, 42, t)
+}
@@ -0,0 +1,63 @@
+package goboy
+
+// Model is a console of the Game Boy family. Games tell them apart
+// from the registers left by the boot ROM.
+type Model int
+
+// The models, the zero value picks the one the cartridge was made for
+const (
+	ModelDMG0 Model = iota + 1 // early Japanese Game Boy
+	ModelDMG                   // Game Boy
+	ModelMGB                   // Game Boy Pocket and Light
+	ModelSGB                   // Super Game Boy
+	ModelSGB2                  // Super Game Boy 2
+	ModelCGB                   // Game Boy Color
+	ModelAGB                   // Game Boy Advance
+)
+
+var modelNames = map[Model]string{
+	ModelDMG0: "DMG0",
+	ModelDMG:  "DMG",
+	ModelMGB:  "MGB",
+	ModelSGB:  "SGB",
+	ModelSGB2: "SGB2",
+	ModelCGB:  "CGB",
+	ModelAGB:  "AGB",
+}
+
+func (m Model) String() string {
+	if name, ok := modelNames[m]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// cgb tells if the model has the CGB hardware, whatever the mode it runs in
+func (m Model) cgb() bool {
+	return m == ModelCGB || m == ModelAGB
+}
+
+// sgb tells if the model can receive the SGB packets
+func (m Model) sgb() bool {
+	return m == ModelSGB || m == ModelSGB2
+}
+
+// bootROMSize returns the size of the boot ROM of the model
+func (m Model) bootROMSize() int {
+	if m.cgb() {
+		return cgbBootROMSize
+	}
+	return bootROMSize
+}
+
+// detectModel returns the model a cartridge was made for
+func detectModel(header Header) Model {
+	switch {
+	case header.CGB():
+		return ModelCGB
+	case header.SGB():
+		return ModelSGB
+	default:
+		return ModelDMG
+	}
+}
@@ -0,0 +1,159 @@
+package goboy
+
+import "testing"
+
+// Games detect the model from the registers left by the boot ROM
+func TestModelRegisters(t *testing.T) {
+	tests := []struct {
+		model      Model
+		cgb        bool
+		af, bc, hl uint16
+	}{
+		{ModelDMG0, false, 0x0100, 0xff13, 0x8403},
+		{ModelDMG, false, 0x01b0, 0x0013, 0x014d},
+		{ModelMGB, false, 0xffb0, 0x0013, 0x014d},
+		{ModelSGB, false, 0x0100, 0x0014, 0xc060},
+		{ModelSGB2, false, 0xff00, 0x0014, 0xc060},
+		{ModelCGB, true, 0x1180, 0x0000, 0x000d},
+		{ModelAGB, true, 0x1100, 0x0100, 0x000d},
+		{ModelCGB, false, 0x1180, 0x0000, 0x007c},
+		{ModelAGB, false, 0x1100, 0x0100, 0x007c},
+	}
+	for _, test := range tests {
+		rom := make([]byte, 0x8000)
+		if test.cgb {
+			rom[0x143] = 0x80
+		}
+		rom[0x14d] = 1
+		gb, err := NewGameBoy(&rom, WithModel(test.model), WithoutBootROM())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := gb.CPU
+		af := uint16(c.A)<<8 | uint16(c.F)
+		bc := uint16(c.B)<<8 | uint16(c.C)
+		hl := uint16(c.H)<<8 | uint16(c.L)
+		if af != test.af || bc != test.bc || hl != test.hl {
+			t.Errorf("%v (CGB game: %v): expected AF=%#04x BC=%#04x HL=%#04x, got AF=%#04x BC=%#04x HL=%#04x",
+				test.model, test.cgb, test.af, test.bc, test.hl, af, bc, hl)
+		}
+	}
+}
+
+func TestModelDetection(t *testing.T) {
+	rom := make([]byte, 0x8000)
+	rom[0x146] = 0x03
+	rom[0x14b] = 0x33
+	gb, err := NewGameBoy(&rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gb.Model != ModelSGB || gb.SGB == nil {
+		t.Errorf("Expected an SGB, got %v", gb.Model)
+	}
+
+	// The SGB packets are only listened to on an SGB
+	gb, err = NewGameBoy(&rom, WithModel(ModelDMG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gb.SGB != nil {
+		t.Error("Expected no SGB support on a DMG")
+	}
+
+	// A CGB game runs in DMG mode on a DMG
+	rom[0x143] = 0x80
+	gb, err = NewGameBoy(&rom, WithModel(ModelDMG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gb.Memory.cgb || gb.PPU.cgb {
+		t.Error("Expected the DMG mode")
+	}
+}
+
+// The DMG games are colored on a CGB, with the palettes of the boot ROM
+func TestCompatibilityPalettes(t *testing.T) {
+	rom := make([]byte, 0x8000)
+	gb, err := NewGameBoy(&rom, WithModel(ModelCGB), WithoutBootROM())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := gb.PPU
+	if p.cgb || !p.compatibility || gb.Memory.cgb {
+		t.Fatal("Expected the DMG compatibility mode")
+	}
+	gb.Memory.Assert(0xff4f, 0xff, t)
+	if color := p.bgColor(fifoPixel{color: 1}); color != compatPalettes[0][3] { // BGP=0xfc
+		t.Errorf("Expected BG color %#04x, got %#04x", compatPalettes[0][3], color)
+	}
+	p.obp1 = 0xe4
+	if color := p.objColor(fifoPixel{color: 1, palette: attrPalette}); color != compatPalettes[2][1] {
+		t.Errorf("Expected OBJ color %#04x, got %#04x", compatPalettes[2][1], color)
+	}
+}
+
+// The CGB boot ROM switches to the DMG mode with KEY0
+func TestKEY0(t *testing.T) {
+	rom := make([]byte, 0x8000)
+	gb, err := NewGameBoy(&rom, WithModel(ModelCGB), WithBootROM(make([]byte, cgbBootROMSize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := gb.Memory
+	if !m.cgb {
+		t.Fatal("Expected the CGB to start in CGB mode")
+	}
+	m.Write(0xff4c, 0x04)
+	m.Write(0xff50, 0x01)
+	if m.cgb || !gb.PPU.compatibility {
+		t.Error("Expected the DMG compatibility mode")
+	}
+}
+
+// Writing STAT raises an interrupt on the DMG, not on the CGB
+func TestSTATWriteBug(t *testing.T) {
+	for _, model := range []Model{ModelDMG, ModelCGB} {
+		irq := interrupts{}
+		p := ppu{irq: &irq, model: model}
+		p.write(0xff40, lcdcEnable)
+		for p.mode != modeHBlank {
+			p.tickDot()
+		}
+		irq.flags = 0
+		p.write(0xff41, 0)
+		if raised := irq.flags&intLCDStat != 0; raised == model.cgb() {
+			t.Errorf("%v: unexpected STAT interrupt: %v", model, raised)
+		}
+	}
+}
+
+func TestAPUModelQuirks(t *testing.T) {
+	for _, model := range []Model{ModelDMG, ModelCGB} {
+		a := newTestAPU()
+		a.model = model
+		a.write(0xff30, 0x12)
+		a.write(0xff1a, 0x80) // DAC on
+		a.write(0xff1e, 0x80) // trigger
+
+		// While channel 3 plays, the CGB accesses the byte being played
+		expected := byte(0xff)
+		if model.cgb() {
+			expected = 0x12
+		}
+		if value := a.read(0xff3f); value != expected {
+			t.Errorf("%v: expected wave RAM to read %#02x, got %#02x", model, expected, value)
+		}
+
+		// The DMG loads the length counters when powered off
+		a.write(0xff26, 0)
+		a.write(0xff20, 0x3e)
+		length := 0
+		if !model.cgb() {
+			length = 2
+		}
+		if a.ch4.length != length {
+			t.Errorf("%v: expected a length of %d, got %d", model, length, a.ch4.length)
+		}
+	}
+}
@@ -0,0 +1,558 @@
+package goboy
+
+// Dimensions of the LCD in pixels
+const (
+	ScreenWidth  = 160
+	ScreenHeight = 144
+)
+
+// Timings of the PPU, in dots (one dot is a clock cycle, 4 per M-cycle)
+const (
+	dotsPerLine   = 456
+	oamScanDots   = 80
+	drawingDots   = 172 // the minimal length of mode 3
+	linesPerFrame = 154
+)
+
+// PPU modes, as reported in STAT
+const (
+	modeHBlank  byte = 0
+	modeVBlank  byte = 1
+	modeOAMScan byte = 2
+	modeDrawing byte = 3
+)
+
+// LCDC bits
+const (
+	lcdcBGEnable      byte = 1 << iota // BG and window enable
+	lcdcOBJEnable                      // sprites enable
+	lcdcOBJSize                        // 8x16 sprites
+	lcdcBGTileMap                      // BG tile map at 0x9c00
+	lcdcTileData                       // tile data at 0x8000 with unsigned indexes
+	lcdcWindowEnable                   // window enable
+	lcdcWindowTileMap                  // window tile map at 0x9c00
+	lcdcEnable                         // LCD and PPU enable
+)
+
+// Sprite attributes, the BG map attributes of the CGB use the same bits
+const (
+	attrCGBPalette byte = 0x07 // CGB palette number
+	attrBank       byte = 0x08 // CGB VRAM bank of the tile
+	attrPalette    byte = 0x10 // DMG palette, OBP1 when set
+	attrXFlip      byte = 0x20
+	attrYFlip      byte = 0x40
+	attrPriority   byte = 0x80 // BG and window colors 1-3 over the sprite
+)
+
+// Frame is a picture of the LCD, each pixel is a RGB555 color
+// (red in the low bits)
+type Frame [ScreenHeight][ScreenWidth]uint16
+
+// dmgColors are the RGB555 colors of the 4 shades of the DMG,
+// from white to black
+var dmgColors = [4]uint16{0x7fff, 0x5294, 0x294a, 0x0000}
+
+// paletteRAM holds the 8 color palettes of the CGB, 4 RGB555 colors
+// each. It is accessed through an index that can increment itself.
+type paletteRAM struct {
+	data  [64]byte
+	index byte // bit 7: increment after the writes
+}
+
+func (r *paletteRAM) readIndex() byte {
+	return r.index | 0x40
+}
+
+func (r *paletteRAM) writeIndex(value byte) {
+	r.index = value & 0xbf
+}
+
+func (r *paletteRAM) readData(accessible bool) byte {
+	if !accessible {
+		return 0xff
+	}
+	return r.data[r.index&0x3f]
+}
+
+// writeData writes at the index, which is incremented even when
+// the palettes are not accessible
+func (r *paletteRAM) writeData(value byte, accessible bool) {
+	if accessible {
+		r.data[r.index&0x3f] = value
+	}
+	if r.index&0x80 != 0 {
+		r.index = 0x80 | (r.index+1)&0x3f
+	}
+}
+
+// color returns a color of a palette
+func (r *paletteRAM) color(palette, color byte) uint16 {
+	i := int(palette&7)*8 + int(color)*2
+	return uint16(r.data[i]) | uint16(r.data[i+1]&0x7f)<<8
+}
+
+// sprite is an entry of the OAM selected for a scanline
+type sprite struct {
+	y, x  int
+	tile  byte
+	attrs byte
+	index int // position in the OAM, used for priority
+}
+
+// ppu emulates the Pixel Processing Unit
+type ppu struct {
+	irq   *interrupts
+	speed *speed // the PPU does not follow the double speed of the CPU
+	model Model
+	cgb   bool         // CGB mode: VRAM banks, color palettes and tile attributes
+	vram  [0x4000]byte // 2 banks of 8kB, the second one only in CGB mode
+	oam   [0xa0]byte
+
+	lcdc byte
+	stat byte // only the interrupt sources bits are stored
+	scy  byte
+	scx  byte
+	ly   byte
+	lyc  byte
+	bgp  byte
+	obp0 byte
+	obp1 byte
+	wy   byte
+	wx   byte
+
+	vbk        byte // VRAM bank of the CPU
+	bgPalette  paletteRAM
+	objPalette paletteRAM
+
+	compatibility bool // DMG game on a CGB, colored by the palettes 0 and 1
+
+	mode       byte
+	dot        int  // position in the current scanline
+	windowLine int  // internal line counter of the window
+	windowY    bool // WY matched LY during this frame
+	statLine   bool // STAT interrupts are raised on the rising edges of this line
+
+	fifo *pixelFIFO // renders with the pixel FIFO instead of whole scanlines
+
+	back   Frame // the frame being drawn
+	front  Frame // the last complete frame
+	frames uint64
+}
+
+func (p *ppu) step() {
+	if p.lcdc&lcdcEnable == 0 {
+		return
+	}
+	for i := p.speed.dots(); i > 0; i-- {
+		p.tickDot()
+	}
+}
+
+// tickDot advances the PPU by one dot
+func (p *ppu) tickDot() {
+	p.dot++
+	if p.dot == dotsPerLine {
+		p.dot = 0
+		p.ly++
+		if p.ly == linesPerFrame {
+			p.ly = 0
+			p.windowLine = 0
+			p.windowY = false
+		}
+	}
+
+	switch {
+	case p.ly >= ScreenHeight:
+		if p.ly == ScreenHeight && p.dot == 0 {
+			p.mode = modeVBlank
+			p.front = p.back
+			p.frames++
+			p.irq.request(intVBlank)
+		}
+	case p.dot == 0:
+		p.mode = modeOAMScan
+		if p.ly == p.wy {
+			p.windowY = true
+		}
+	case p.dot == oamScanDots:
+		p.mode = modeDrawing
+		if p.fifo != nil {
+			p.fifo.start(p)
+		} else {
+			p.renderLine()
+		}
+	case p.mode == modeDrawing && p.drawn():
+		p.mode = modeHBlank
+	}
+	p.updateStat()
+}
+
+// drawn advances the rendering during mode 3, it returns true
+// when the line is complete
+func (p *ppu) drawn() bool {
+	if p.fifo != nil {
+		return p.fifo.tick(p)
+	}
+	return p.dot == oamScanDots+drawingDots
+}
+
+// updateStat raises the STAT interrupt on a rising edge of its sources
+func (p *ppu) updateStat() {
+	line := (p.stat&0x40 != 0 && p.ly == p.lyc) ||
+		(p.stat&0x08 != 0 && p.mode == modeHBlank) ||
+		(p.stat&0x10 != 0 && p.mode == modeVBlank) ||
+		(p.stat&0x20 != 0 && p.mode == modeOAMScan)
+	if line && !p.statLine {
+		p.irq.request(intLCDStat)
+	}
+	p.statLine = line
+}
+
+// tileRow returns the two bytes of a row of a tile, the attributes
+// select the VRAM bank and flip the tile vertically
+func (p *ppu) tileRow(tile byte, row int, attrs byte, sprite bool) (byte, byte) {
+	var address int
+	if sprite || p.lcdc&lcdcTileData != 0 {
+		address = int(tile) * 16
+	} else {
+		address = 0x1000 + int(int8(tile))*16
+	}
+	if attrs&attrYFlip != 0 && !sprite { // sprites are flipped on their whole height
+		row = 7 - row
+	}
+	if attrs&attrBank != 0 && p.cgb {
+		address += 0x2000
+	}
+	address += row * 2
+	return p.vram[address], p.vram[address+1]
+}
+
+// mapAttributes returns the attributes of a tile of a BG map,
+// they are in the VRAM bank 1 of the CGB
+func (p *ppu) mapAttributes(address int) byte {
+	if !p.cgb {
+		return 0
+	}
+	return p.vram[0x2000+address]
+}
+
+// pixel returns the color number of a pixel of a tile row
+func pixel(low, high byte, x int) byte {
+	bit := uint(7 - x)
+	return (low>>bit)&1 | ((high>>bit)&1)<<1
+}
+
+// shade applies a palette to a color number
+func shade(palette, color byte) byte {
+	return (palette >> (color * 2)) & 3
+}
+
+// bgColor returns the RGB555 color of a BG or window pixel
+func (p *ppu) bgColor(px fifoPixel) uint16 {
+	if p.cgb {
+		return p.bgPalette.color(px.palette, px.color)
+	}
+	return p.dmgColor(&p.bgPalette, 0, shade(p.bgp, px.color))
+}
+
+// objColor returns the RGB555 color of a sprite pixel, whose palette is
+// the number of a CGB palette or the palette bit of the DMG attributes
+func (p *ppu) objColor(px fifoPixel) uint16 {
+	if p.cgb {
+		return p.objPalette.color(px.palette, px.color)
+	}
+	if px.palette&attrPalette != 0 {
+		return p.dmgColor(&p.objPalette, 1, shade(p.obp1, px.color))
+	}
+	return p.dmgColor(&p.objPalette, 0, shade(p.obp0, px.color))
+}
+
+// dmgColor returns the color of a DMG shade, taken from a CGB palette
+// in DMG compatibility mode
+func (p *ppu) dmgColor(palettes *paletteRAM, palette, shade byte) uint16 {
+	if p.compatibility {
+		return palettes.color(palette, shade)
+	}
+	return dmgColors[shade]
+}
+
+// spritePalette returns the palette of a sprite as stored in its pixels
+func (p *ppu) spritePalette(attrs byte) byte {
+	if p.cgb {
+		return attrs & attrCGBPalette
+	}
+	return attrs & attrPalette
+}
+
+// bgOverSprite tells if a BG pixel hides a sprite pixel. On CGB,
+// the BG enable bit of LCDC gives the priority to the sprites.
+func (p *ppu) bgOverSprite(bg fifoPixel, spritePriority bool) bool {
+	if bg.color == 0 || (p.cgb && p.lcdc&lcdcBGEnable == 0) {
+		return false
+	}
+	return spritePriority || bg.priority
+}
+
+// scanSprites returns the (at most 10) sprites on the current line
+func (p *ppu) scanSprites() []sprite {
+	height := 8
+	if p.lcdc&lcdcOBJSize != 0 {
+		height = 16
+	}
+	sprites := make([]sprite, 0, 10)
+	for i := 0; i < 40 && len(sprites) < 10; i++ {
+		y := int(p.oam[i*4]) - 16
+		if y <= int(p.ly) && int(p.ly) < y+height {
+			sprites = append(sprites, sprite{y, int(p.oam[i*4+1]) - 8, p.oam[i*4+2], p.oam[i*4+3], i})
+		}
+	}
+	return sprites
+}
+
+// renderLine draws the current scanline in the back buffer
+func (p *ppu) renderLine() {
+	var bg [ScreenWidth]fifoPixel // BG and window pixels, for sprite priority
+	line := &p.back[p.ly]
+
+	// On CGB, the BG enable bit only affects the priority
+	if p.lcdc&lcdcBGEnable != 0 || p.cgb {
+		p.renderBackground(line, &bg)
+	} else {
+		for x := range line {
+			line[x] = p.bgColor(fifoPixel{})
+		}
+	}
+	if p.lcdc&lcdcOBJEnable != 0 {
+		p.renderSprites(line, &bg)
+	}
+}
+
+func (p *ppu) renderBackground(line *[ScreenWidth]uint16, bg *[ScreenWidth]fifoPixel) {
+	windowX := int(p.wx) - 7
+	window := p.lcdc&lcdcWindowEnable != 0 && p.windowY && windowX < ScreenWidth
+
+	for x := 0; x < ScreenWidth; x++ {
+		var mapAddress, tx, ty int
+		if window && x >= windowX {
+			mapAddress = 0x1800
+			if p.lcdc&lcdcWindowTileMap != 0 {
+				mapAddress = 0x1c00
+			}
+			tx, ty = x-windowX, p.windowLine
+		} else {
+			mapAddress = 0x1800
+			if p.lcdc&lcdcBGTileMap != 0 {
+				mapAddress = 0x1c00
+			}
+			tx, ty = (x+int(p.scx))&0xff, (int(p.ly)+int(p.scy))&0xff
+		}
+		mapAddress += (ty/8)*32 + tx/8
+		attrs := p.mapAttributes(mapAddress)
+		low, high := p.tileRow(p.vram[mapAddress], ty%8, attrs, false)
+		column := tx % 8
+		if attrs&attrXFlip != 0 {
+			column = 7 - column
+		}
+		bg[x] = fifoPixel{pixel(low, high, column), attrs & attrCGBPalette, attrs&attrPriority != 0, 0}
+		line[x] = p.bgColor(bg[x])
+	}
+	if window {
+		p.windowLine++
+	}
+}
+
+func (p *ppu) renderSprites(line *[ScreenWidth]uint16, bg *[ScreenWidth]fifoPixel) {
+	sprites := p.scanSprites()
+	height := 8
+	if p.lcdc&lcdcOBJSize != 0 {
+		height = 16
+	}
+
+	for x := 0; x < ScreenWidth; x++ {
+		// On DMG, the sprite with the smallest X wins, then the first in OAM.
+		// On CGB, the first in OAM wins.
+		var best *sprite
+		var bestColor byte
+		for i := range sprites {
+			s := &sprites[i]
+			if x < s.x || x >= s.x+8 {
+				continue
+			}
+			if best != nil && (p.cgb || best.x < s.x || (best.x == s.x && best.index < s.index)) {
+				continue
+			}
+			row := int(p.ly) - s.y
+			if s.attrs&attrYFlip != 0 {
+				row = height - 1 - row
+			}
+			tile := s.tile
+			if height == 16 {
+				tile &= 0xfe
+			}
+			column := x - s.x
+			if s.attrs&attrXFlip != 0 {
+				column = 7 - column
+			}
+			low, high := p.tileRow(tile, row, s.attrs, true)
+			color := pixel(low, high, column)
+			if color == 0 { // transparent
+				continue
+			}
+			best, bestColor = s, color
+		}
+		if best == nil || p.bgOverSprite(bg[x], best.attrs&attrPriority != 0) {
+			continue
+		}
+		line[x] = p.objColor(fifoPixel{bestColor, p.spritePalette(best.attrs), false, best.index})
+	}
+}
+
+// vramAccessible tells if the CPU can access the VRAM
+func (p *ppu) vramAccessible() bool {
+	return p.lcdc&lcdcEnable == 0 || p.mode != modeDrawing
+}
+
+// oamAccessible tells if the CPU can access the OAM
+func (p *ppu) oamAccessible() bool {
+	return p.lcdc&lcdcEnable == 0 || p.mode == modeHBlank || p.mode == modeVBlank
+}
+
+func (p *ppu) readVRAM(address uint16) byte {
+	if !p.vramAccessible() {
+		return 0xff
+	}
+	return p.vram[int(p.vbk)*0x2000+int(address-0x8000)]
+}
+
+func (p *ppu) writeVRAM(address uint16, value byte) {
+	if p.vramAccessible() {
+		p.vram[int(p.vbk)*0x2000+int(address-0x8000)] = value
+	}
+}
+
+func (p *ppu) readOAM(address uint16) byte {
+	if !p.oamAccessible() {
+		return 0xff
+	}
+	return p.oam[address-0xfe00]
+}
+
+func (p *ppu) writeOAM(address uint16, value byte) {
+	if p.oamAccessible() {
+		p.oam[address-0xfe00] = value
+	}
+}
+
+func (p *ppu) read(address uint16) byte {
+	switch address {
+	case 0xff40:
+		return p.lcdc
+	case 0xff41:
+		value := 0x80 | p.stat | p.mode
+		if p.ly == p.lyc {
+			value |= 0x04
+		}
+		return value
+	case 0xff42:
+		return p.scy
+	case 0xff43:
+		return p.scx
+	case 0xff44:
+		return p.ly
+	case 0xff45:
+		return p.lyc
+	case 0xff47:
+		return p.bgp
+	case 0xff48:
+		return p.obp0
+	case 0xff49:
+		return p.obp1
+	case 0xff4a:
+		return p.wy
+	case 0xff4b:
+		return p.wx
+	}
+	if !p.cgb { // the CGB registers are not mapped
+		return 0xff
+	}
+	switch address {
+	case 0xff4f:
+		return 0xfe | p.vbk
+	case 0xff68:
+		return p.bgPalette.readIndex()
+	case 0xff69:
+		return p.bgPalette.readData(p.vramAccessible())
+	case 0xff6a:
+		return p.objPalette.readIndex()
+	case 0xff6b:
+		return p.objPalette.readData(p.vramAccessible())
+	default:
+		return 0xff
+	}
+}
+
+func (p *ppu) write(address uint16, value byte) {
+	switch address {
+	case 0xff40:
+		p.setLCDC(value)
+	case 0xff41:
+		// On the DMG, all the sources are enabled during the write, which
+		// raises an interrupt in HBlank, VBlank or when LY=LYC
+		if !p.model.cgb() && p.lcdc&lcdcEnable != 0 {
+			p.stat = 0x58
+			p.updateStat()
+		}
+		p.stat = value & 0x78
+		p.updateStat()
+	case 0xff42:
+		p.scy = value
+	case 0xff43:
+		p.scx = value
+	case 0xff44: // read only
+	case 0xff45:
+		p.lyc = value
+		p.updateStat()
+	case 0xff47:
+		p.bgp = value
+	case 0xff48:
+		p.obp0 = value
+	case 0xff49:
+		p.obp1 = value
+	case 0xff4a:
+		p.wy = value
+	case 0xff4b:
+		p.wx = value
+	}
+	if !p.cgb {
+		return
+	}
+	switch address {
+	case 0xff4f:
+		p.vbk = value & 1
+	case 0xff68:
+		p.bgPalette.writeIndex(value)
+	case 0xff69: // the palettes are locked with the VRAM
+		p.bgPalette.writeData(value, p.vramAccessible())
+	case 0xff6a:
+		p.objPalette.writeIndex(value)
+	case 0xff6b:
+		p.objPalette.writeData(value, p.vramAccessible())
+	}
+}
+
+func (p *ppu) setLCDC(value byte) {
+	wasEnabled := p.lcdc&lcdcEnable != 0
+	p.lcdc = value
+	switch enabled := value&lcdcEnable != 0; {
+	case wasEnabled && !enabled: // the PPU is reset when the LCD is turned off
+		p.ly = 0
+		p.dot = 0
+		p.mode = modeHBlank
+		p.windowLine = 0
+		p.windowY = false
+	case !wasEnabled && enabled: // and starts a new frame when turned on
+		p.mode = modeOAMScan
+		p.windowY = p.ly == p.wy
+		p.updateStat()
+	}
+}
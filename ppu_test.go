@@ -0,0 +1,84 @@
+package goboy
+
+import "testing"
+
+func newTestPPU() *ppu {
+	p := &ppu{irq: &interrupts{}}
+	p.bgp = 0xe4 // identity palette
+	p.obp0 = 0xe4
+	p.write(0xff40, lcdcEnable|lcdcBGEnable|lcdcOBJEnable|lcdcTileData)
+	return p
+}
+
+// runLines steps the PPU for a number of scanlines
+func (p *ppu) runLines(lines int) {
+	for i := 0; i < lines*dotsPerLine/4; i++ {
+		p.step()
+	}
+}
+
+func TestPPUModes(t *testing.T) {
+	p := newTestPPU()
+	p.write(0xff41, 0x08) // HBlank STAT interrupt
+	for i := 0; i < 20; i++ {
+		p.step()
+	}
+	if mode := p.read(0xff41) & 3; mode != modeDrawing {
+		t.Error("Expected mode 3 after 80 dots, got", mode)
+	}
+	p.runLines(1)
+	if p.ly != 1 || p.irq.flags != intLCDStat {
+		t.Errorf("Expected LY=1 and a STAT interrupt, got LY=%d IF=%#02x", p.ly, p.irq.flags)
+	}
+	p.runLines(ScreenHeight - 1)
+	if p.ly != ScreenHeight || p.mode != modeVBlank || p.irq.flags&intVBlank == 0 || p.frames != 1 {
+		t.Errorf("Expected VBlank, got LY=%d mode=%d IF=%#02x", p.ly, p.mode, p.irq.flags)
+	}
+	p.runLines(linesPerFrame - ScreenHeight)
+	if p.ly != 0 {
+		t.Error("Expected a new frame, got LY", p.ly)
+	}
+}
+
+func TestLYCInterrupt(t *testing.T) {
+	p := newTestPPU()
+	p.write(0xff45, 10)
+	p.write(0xff41, 0x40)
+	p.runLines(10)
+	if p.irq.flags != intLCDStat || p.read(0xff41)&0x04 == 0 {
+		t.Errorf("Expected a LYC interrupt, got IF=%#02x STAT=%#02x", p.irq.flags, p.read(0xff41))
+	}
+}
+
+func TestBackgroundAndSprites(t *testing.T) {
+	p := newTestPPU()
+	// Tile 1 has a single black pixel on its top left corner
+	p.vram[0x10] = 0x80
+	p.vram[0x11] = 0x80
+	p.vram[0x1800] = 1 // top left tile of the BG map
+	// A sprite using tile 1, flipped horizontally, next to it
+	copy(p.oam[:], []byte{16, 16, 1, attrXFlip})
+	p.runLines(ScreenHeight)
+
+	frame := p.front
+	if frame[0][0] != dmgColors[3] || frame[0][1] != dmgColors[0] || frame[1][0] != dmgColors[0] {
+		t.Error("Expected a single black pixel in the background tile")
+	}
+	if frame[0][8] != dmgColors[0] || frame[0][15] != dmgColors[3] {
+		t.Error("Expected the flipped sprite pixel at x=15")
+	}
+}
+
+func TestSpritePriority(t *testing.T) {
+	p := newTestPPU()
+	p.vram[0x10] = 0xff // tile 1, color 1 on its first row
+	p.obp0 = 0x0c       // color 1 is shade 3
+	p.obp1 = 0x08       // color 1 is shade 2
+	copy(p.oam[:], []byte{16, 12, 1, attrPalette, 16, 8, 1, 0})
+	p.runLines(ScreenHeight)
+
+	// The sprites overlap on 4-7, the one with the smallest X is on top
+	if p.front[0][0] != dmgColors[3] || p.front[0][4] != dmgColors[3] || p.front[0][8] != dmgColors[2] {
+		t.Error("Expected the leftmost sprite to be drawn first, got", p.front[0][:12])
+	}
+}
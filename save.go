@@ -0,0 +1,92 @@
+package goboy
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// saveInterval is the number of M-cycles between two periodic flushes
+const saveInterval = 5 * cyclesPerSecond
+
+// SavePath returns the path of the save file of a ROM, next to it
+func SavePath(romPath string) string {
+	return strings.TrimSuffix(romPath, filepath.Ext(romPath)) + ".sav"
+}
+
+// saveFile persists the battery-backed RAM and RTC of the cartridge
+type saveFile struct {
+	path   string
+	atomic bool   // write a temporary file then rename it
+	saved  []byte // last state written, the flushes without changes are skipped
+	next   uint64 // cycle of the next periodic flush
+}
+
+// load restores the state of the cartridge, a missing file is not an error
+func (s *saveFile) load(m MBC) error {
+	s.next = saveInterval
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := m.load(data); err != nil {
+		return fmt.Errorf("%w %s", err, s.path)
+	}
+	s.saved = data
+	return nil
+}
+
+// flush writes the state of the cartridge if it changed since the last write
+func (s *saveFile) flush(m MBC) error {
+	data := m.save()
+	if data == nil || bytes.Equal(data, s.saved) {
+		return nil
+	}
+	if err := s.write(data); err != nil {
+		return err
+	}
+	s.saved = data
+	return nil
+}
+
+// autosave flushes the state once every saveInterval cycles
+func (s *saveFile) autosave(m MBC, cycles uint64) error {
+	if cycles < s.next {
+		return nil
+	}
+	s.next = cycles + saveInterval
+	return s.flush(m)
+}
+
+func (s *saveFile) write(data []byte) error {
+	if !s.atomic {
+		return ioutil.WriteFile(s.path, data, 0644)
+	}
+	// The rename replaces the save at once, a crash can not leave it truncated
+	f, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
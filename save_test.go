@@ -0,0 +1,74 @@
+package goboy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSavePath(t *testing.T) {
+	if path := SavePath("roms/tetris.gb"); path != "roms/tetris.sav" {
+		t.Error("Expected roms/tetris.sav, got", path)
+	}
+}
+
+func TestSaveFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goboy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "game.sav")
+
+	rom := bankedROM(0x8000, 0x03, 2)
+	m := loadMBC1(&rom)
+	s := &saveFile{path: path, atomic: true}
+	if err := s.load(m); err != nil {
+		t.Fatal("Expected a missing save to be ignored, got", err)
+	}
+	m.write(0x0000, 0x0a)
+	m.write(0xa000, 42)
+
+	// Nothing is written before the interval
+	if err := s.autosave(m, saveInterval-1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Expected no save before the interval")
+	}
+	if err := s.autosave(m, saveInterval); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil || len(data) != 0x2000 || data[0] != 42 {
+		t.Fatal("Expected the RAM in the save, got", len(data), "bytes", err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Error("Expected the temporary file to be renamed, got", len(files), "files")
+	}
+
+	// Unchanged saves are not written again
+	os.Remove(path)
+	if err := s.flush(m); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Expected an unchanged save to be skipped")
+	}
+
+	m = loadMBC1(&rom)
+	s = &saveFile{path: path}
+	m.write(0x0000, 0x0a)
+	m.write(0xa000, 43)
+	s.flush(m)
+	m = loadMBC1(&rom)
+	if err := s.load(m); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m.ram, s.saved) || m.ram[0] != 43 {
+		t.Error("Expected the RAM to be restored")
+	}
+}
@@ -0,0 +1,134 @@
+package goboy
+
+import "io"
+
+// Bits of SC (0xff02)
+const (
+	scTransfer byte = 0x80 // set to start a transfer, cleared when done
+	scFast     byte = 0x02 // CGB mode only, 262144 bits per second
+	scInternal byte = 0x01 // the Game Boy clocks the transfer
+)
+
+// Bits of the internal counter of the timer clocking the transfers:
+// 8192 bits per second, 262144 with the fast clock
+const (
+	serialBit     uint16 = 1 << 8
+	serialFastBit uint16 = 1 << 3
+)
+
+// LinkPeer is what is plugged at the other end of the link cable.
+// Exchange is called when the Game Boy starts a transfer with its own
+// clock: it gets the byte sent and returns the byte received in return.
+type LinkPeer interface {
+	Exchange(out byte) byte
+}
+
+// unplugged is the peer when there is no cable, the line stays high
+type unplugged struct{}
+
+func (unplugged) Exchange(out byte) byte {
+	return 0xff
+}
+
+// CapturePeer writes every byte sent by the Game Boy to a writer, which
+// is how the test ROMs report their results. It behaves as an unplugged
+// cable otherwise.
+type CapturePeer struct {
+	w   io.Writer
+	err error
+}
+
+// NewCapturePeer returns a peer capturing the bytes sent to w
+func NewCapturePeer(w io.Writer) *CapturePeer {
+	return &CapturePeer{w: w}
+}
+
+// Exchange writes the byte sent, and returns 0xff
+func (c *CapturePeer) Exchange(out byte) byte {
+	if c.err == nil {
+		_, c.err = c.w.Write([]byte{out})
+	}
+	return 0xff
+}
+
+// Err returns the first error of the writer
+func (c *CapturePeer) Err() error {
+	return c.err
+}
+
+// serial emulates the serial port, SB (0xff01) and SC (0xff02). The bits
+// are shifted out and in one by one, on the falling edges of a bit of the
+// internal counter of the timer.
+type serial struct {
+	irq   *interrupts
+	timer *timer
+	peer  LinkPeer
+	speed *speed // the link cable counts the time in dots
+	cgb   bool   // CGB mode, where the fast clock is available
+
+	sb       byte
+	sc       byte
+	incoming byte // byte received from the peer, shifted in bit by bit
+	bits     int  // bits shifted during the current transfer
+	clock    bool // last state of the clock bit
+}
+
+func (s *serial) step() {
+	bit := serialBit
+	if s.cgb && s.sc&scFast != 0 {
+		bit = serialFastBit
+	}
+	clock := s.timer.counter&bit != 0
+	falling := s.clock && !clock
+	s.clock = clock
+	if falling && s.sc&scTransfer != 0 && s.sc&scInternal != 0 {
+		s.shift()
+	}
+}
+
+// shift moves one bit out of SB and one bit in from the peer
+func (s *serial) shift() {
+	s.sb = s.sb<<1 | s.incoming>>7
+	s.incoming <<= 1
+	s.bits++
+	if s.bits == 8 {
+		s.sc &^= scTransfer
+		s.irq.request(intSerial)
+	}
+}
+
+// receive is a transfer clocked by the peer, which only happens when
+// the Game Boy waits for it with the external clock. It returns the byte
+// sent in exchange.
+func (s *serial) receive(in byte) byte {
+	if s.sc&scTransfer == 0 || s.sc&scInternal != 0 {
+		return 0xff
+	}
+	out := s.sb
+	s.sb = in
+	s.sc &^= scTransfer
+	s.irq.request(intSerial)
+	return out
+}
+
+func (s *serial) read(address uint16) byte {
+	if address == 0xff01 {
+		return s.sb
+	}
+	if s.cgb {
+		return s.sc | 0x7c
+	}
+	return s.sc | 0x7e
+}
+
+func (s *serial) write(address uint16, value byte) {
+	if address == 0xff01 {
+		s.sb = value
+		return
+	}
+	s.sc = value & (scTransfer | scFast | scInternal)
+	if s.sc&scTransfer != 0 && s.sc&scInternal != 0 {
+		s.bits = 0
+		s.incoming = s.peer.Exchange(s.sb)
+	}
+}
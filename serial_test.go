@@ -0,0 +1,80 @@
+package goboy
+
+import (
+	"bytes"
+	"testing"
+)
+
+// transfer starts a transfer clocked by the Game Boy and returns
+// the number of M-cycles it took
+func transfer(gb gameBoy, value, sc byte) int {
+	gb.Memory.Write(0xff01, value)
+	gb.Memory.Write(0xff02, sc)
+	cycles := 0
+	for gb.Memory.Read(0xff02)&scTransfer != 0 && cycles < 0x10000 {
+		gb.Clock.tick()
+		cycles++
+	}
+	return cycles
+}
+
+func TestSerialUnplugged(t *testing.T) {
+	gb := newTestGameBoy(t, false)
+	gb.IRQ.flags = 0
+	cycles := transfer(gb, 0x42, 0x81)
+	if cycles < 7*128 || cycles > 9*128 {
+		t.Error("Expected 8 bits at 8192 bits per second, got", cycles)
+	}
+	gb.Memory.Assert(0xff01, 0xff, t)
+	gb.Memory.Assert(0xff02, 0x7f, t)
+	if gb.IRQ.flags != intSerial {
+		t.Errorf("Expected the serial interrupt, got IF=%#02x", gb.IRQ.flags)
+	}
+}
+
+// Test ROMs print their results through the serial port
+func TestSerialCapture(t *testing.T) {
+	var output bytes.Buffer
+	rom := make([]byte, 0x8000)
+	gb, err := NewGameBoy(&rom, WithLinkPeer(NewCapturePeer(&output)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []byte("Passed") {
+		transfer(gb, b, 0x81)
+	}
+	if output.String() != "Passed" {
+		t.Errorf("Expected Passed, got %q", output.String())
+	}
+}
+
+// With the clock of the other side, nothing happens until it is plugged
+func TestSerialExternalClock(t *testing.T) {
+	var output bytes.Buffer
+	rom := make([]byte, 0x8000)
+	gb, err := NewGameBoy(&rom, WithLinkPeer(NewCapturePeer(&output)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cycles := transfer(gb, 0x42, 0x80); cycles != 0x10000 {
+		t.Error("Expected the transfer to wait for the external clock, it took", cycles)
+	}
+	gb.Memory.Assert(0xff01, 0x42, t)
+	if output.Len() != 0 {
+		t.Error("Expected nothing to be sent, got", output.Bytes())
+	}
+}
+
+func TestSerialFastClock(t *testing.T) {
+	gb := newTestGameBoy(t, true)
+	if cycles := transfer(gb, 0x42, 0x83); cycles < 7*4 || cycles > 9*4 {
+		t.Error("Expected 8 bits at 262144 bits per second, got", cycles)
+	}
+	gb.Memory.Assert(0xff02, 0x7f, t)
+
+	// The fast clock does not exist on DMG
+	gb = newTestGameBoy(t, false)
+	if cycles := transfer(gb, 0x42, 0x83); cycles < 7*128 {
+		t.Error("Expected the normal clock on DMG, got", cycles)
+	}
+}
@@ -0,0 +1,406 @@
+package goboy
+
+// Dimensions of the picture of the Super Game Boy, the screen of
+// the Game Boy is in the middle of the border
+const (
+	SGBWidth   = 256
+	SGBHeight  = 224
+	sgbScreenX = (SGBWidth - ScreenWidth) / 2
+	sgbScreenY = (SGBHeight - ScreenHeight) / 2
+)
+
+// SGBFrame is a picture of the Super Game Boy, with its border.
+// Each pixel is a RGB555 color (red in the low bits).
+type SGBFrame [SGBHeight][SGBWidth]uint16
+
+// SGB commands
+const (
+	sgbPAL01   = 0x00
+	sgbPAL23   = 0x01
+	sgbPAL03   = 0x02
+	sgbPAL12   = 0x03
+	sgbATTRBLK = 0x04
+	sgbATTRLIN = 0x05
+	sgbATTRDIV = 0x06
+	sgbATTRCHR = 0x07
+	sgbPALSET  = 0x0a
+	sgbPALTRN  = 0x0b
+	sgbMLTREQ  = 0x11
+	sgbCHRTRN  = 0x13
+	sgbPCTTRN  = 0x14
+	sgbMASKEN  = 0x17
+)
+
+// Masks of the screen set by MASK_EN
+const (
+	maskCancel byte = iota
+	maskFreeze      // the last picture stays on the screen
+	maskBlack
+	maskColor0
+)
+
+const (
+	sgbPacketBits = 128
+	sgbCellsX     = ScreenWidth / 8
+	sgbCellsY     = ScreenHeight / 8
+	sgbMapWidth   = SGBWidth / 8
+	sgbMapHeight  = SGBHeight / 8
+)
+
+// sgb emulates the Super Game Boy. The game sends it commands with
+// the joypad register, the larger data are transferred by displaying
+// them on the screen for a frame.
+type sgb struct {
+	ppu *ppu
+
+	// Packet transfer over P1
+	previous byte // last value written to P1
+	bits     int  // bits received in the current packet, -1 between the packets
+	packet   [16]byte
+	data     []byte // packets of the current command
+
+	players int // 1, 2 or 4 with MLT_REQ
+	player  int // joypad read when P14 and P15 are high
+
+	palettes       [4][4]uint16 // the color 0 is shared
+	systemPalettes [512][4]uint16
+	attributes     [sgbCellsY][sgbCellsX]byte // palette of each cell of the screen
+	mask           byte
+
+	tiles          [256][32]byte // 4 bits per pixel, in the SNES format
+	tileMap        [sgbMapHeight * sgbMapWidth]uint16
+	borderPalettes [4][16]uint16 // palettes 4-7
+
+	transfer      byte   // VRAM transfer waiting for a frame, 0 when none
+	transferFrame uint64 // frame to capture
+
+	frames uint64 // frames of the PPU already composited
+	screen Frame  // last picture of the Game Boy, kept when frozen
+	front  SGBFrame
+}
+
+func newSGB(p *ppu) *sgb {
+	s := &sgb{ppu: p, bits: -1, players: 1}
+	// Until the game sets them, the palettes are the shades of the DMG
+	for i := range s.palettes {
+		s.palettes[i] = dmgColors
+	}
+	return s
+}
+
+// write receives the P1 writes. A packet starts with a reset pulse (P14
+// and P15 low), then each bit is a pulse on P14 (0) or P15 (1).
+func (s *sgb) write(value byte) {
+	value &= 0x30
+	switch {
+	case value == 0x00:
+		s.bits = 0
+		s.packet = [16]byte{}
+	case s.previous == 0x30 && value != 0x30 && s.bits >= 0:
+		s.receive(value == 0x10)
+	}
+	// The next joypad is selected when P15 goes high
+	if s.players > 1 && s.previous&0x20 == 0 && value&0x20 != 0 {
+		s.player = (s.player + 1) % s.players
+	}
+	s.previous = value
+}
+
+// receive adds a bit to the current packet, the bytes are sent LSB first
+func (s *sgb) receive(bit bool) {
+	if s.bits == sgbPacketBits { // the stop bit
+		s.bits = -1
+		if !bit {
+			s.receivePacket()
+		}
+		return
+	}
+	if bit {
+		s.packet[s.bits/8] |= 1 << uint(s.bits%8)
+	}
+	s.bits++
+}
+
+// receivePacket adds a packet to the current command, the first byte
+// of a command is its code and its number of packets
+func (s *sgb) receivePacket() {
+	if len(s.data) == 0 && s.packet[0]&7 == 0 {
+		return
+	}
+	s.data = append(s.data, s.packet[:]...)
+	if len(s.data)/16 >= int(s.data[0]&7) {
+		s.command(s.data)
+		s.data = nil
+	}
+}
+
+// rgb555 reads a little endian RGB555 color
+func rgb555(data []byte) uint16 {
+	return (uint16(data[0]) | uint16(data[1])<<8) & 0x7fff
+}
+
+func (s *sgb) command(d []byte) {
+	switch code := d[0] >> 3; code {
+	case sgbPAL01:
+		s.setPalettes(0, 1, d)
+	case sgbPAL23:
+		s.setPalettes(2, 3, d)
+	case sgbPAL03:
+		s.setPalettes(0, 3, d)
+	case sgbPAL12:
+		s.setPalettes(1, 2, d)
+	case sgbATTRBLK:
+		s.attrBlock(d)
+	case sgbATTRLIN:
+		s.attrLine(d)
+	case sgbATTRDIV:
+		s.attrDivide(d)
+	case sgbATTRCHR:
+		s.attrCharacter(d)
+	case sgbPALSET:
+		for i := range s.palettes {
+			n := int(d[1+i*2]) | int(d[2+i*2]&1)<<8
+			s.palettes[i] = s.systemPalettes[n]
+		}
+		if d[9]&0x40 != 0 {
+			s.mask = maskCancel
+		}
+	case sgbPALTRN, sgbCHRTRN, sgbPCTTRN:
+		s.transfer = code
+		s.transferFrame = s.ppu.frames + 1
+		if code == sgbCHRTRN && d[1]&1 != 0 {
+			s.transfer |= 0x80 // the upper 128 tiles
+		}
+	case sgbMLTREQ:
+		switch d[1] & 3 {
+		case 1:
+			s.players = 2
+		case 3:
+			s.players = 4
+		default:
+			s.players = 1
+		}
+		s.player = 0
+	case sgbMASKEN:
+		s.mask = d[1] & 3
+	}
+}
+
+// setPalettes sets the colors of 2 palettes, and the shared color 0
+func (s *sgb) setPalettes(a, b int, d []byte) {
+	for i := range s.palettes {
+		s.palettes[i][0] = rgb555(d[1:])
+	}
+	for i := 1; i < 4; i++ {
+		s.palettes[a][i] = rgb555(d[1+i*2:])
+		s.palettes[b][i] = rgb555(d[7+i*2:])
+	}
+}
+
+// attrBlock sets the palettes inside, on the border and outside of rectangles
+func (s *sgb) attrBlock(d []byte) {
+	for n, i := int(d[1]), 2; n > 0 && i+6 <= len(d); n, i = n-1, i+6 {
+		control, palettes := d[i]&7, d[i+1]
+		x1, y1, x2, y2 := int(d[i+2]&0x1f), int(d[i+3]&0x1f), int(d[i+4]&0x1f), int(d[i+5]&0x1f)
+		inside, border, outside := palettes&3, palettes>>2&3, palettes>>4&3
+		// The border takes the palette of the only area changed
+		switch control {
+		case 1:
+			control, border = 3, inside
+		case 4:
+			control, border = 6, outside
+		}
+		for y := range s.attributes {
+			for x := range s.attributes[y] {
+				switch {
+				case x > x1 && x < x2 && y > y1 && y < y2:
+					if control&1 != 0 {
+						s.attributes[y][x] = inside
+					}
+				case x >= x1 && x <= x2 && y >= y1 && y <= y2:
+					if control&2 != 0 {
+						s.attributes[y][x] = border
+					}
+				default:
+					if control&4 != 0 {
+						s.attributes[y][x] = outside
+					}
+				}
+			}
+		}
+	}
+}
+
+// attrLine sets the palettes of whole rows or columns
+func (s *sgb) attrLine(d []byte) {
+	for n, i := int(d[1]), 2; n > 0 && i < len(d); n, i = n-1, i+1 {
+		line, palette := int(d[i]&0x1f), d[i]>>5&3
+		if d[i]&0x80 != 0 { // a row
+			if line < sgbCellsY {
+				for x := range s.attributes[line] {
+					s.attributes[line][x] = palette
+				}
+			}
+		} else if line < sgbCellsX {
+			for y := range s.attributes {
+				s.attributes[y][line] = palette
+			}
+		}
+	}
+}
+
+// attrDivide splits the screen in two with a line between them
+func (s *sgb) attrDivide(d []byte) {
+	after, before, line := d[1]&3, d[1]>>2&3, d[1]>>4&3
+	horizontal, at := d[1]&0x40 != 0, int(d[2]&0x1f)
+	for y := range s.attributes {
+		for x := range s.attributes[y] {
+			position := x
+			if horizontal {
+				position = y
+			}
+			switch {
+			case position < at:
+				s.attributes[y][x] = before
+			case position == at:
+				s.attributes[y][x] = line
+			default:
+				s.attributes[y][x] = after
+			}
+		}
+	}
+}
+
+// attrCharacter sets the palettes of cells one by one, 2 bits each
+func (s *sgb) attrCharacter(d []byte) {
+	x, y := int(d[1]), int(d[2])
+	count := int(d[3]) | int(d[4])<<8
+	vertical := d[5]&1 != 0
+	for n := 0; n < count && 6+n/4 < len(d) && x < sgbCellsX && y < sgbCellsY; n++ {
+		s.attributes[y][x] = d[6+n/4] >> uint(6-n%4*2) & 3
+		if vertical {
+			if y++; y == sgbCellsY {
+				y, x = 0, x+1
+			}
+		} else if x++; x == sgbCellsX {
+			x, y = 0, y+1
+		}
+	}
+}
+
+// dmgShade returns the shade of a color of the DMG palette
+func dmgShade(c uint16) byte {
+	for shade, dmg := range dmgColors {
+		if c == dmg {
+			return byte(shade)
+		}
+	}
+	return 0
+}
+
+// capture reads the 4kB displayed for a VRAM transfer. The SGB sees
+// the screen as 256 tiles, 20 per row.
+func capture(screen *Frame) []byte {
+	data := make([]byte, 0x1000)
+	for i := range data {
+		tile, row, plane := i/16, i%16/2, uint(i%2)
+		x0, y := tile%sgbCellsX*8, tile/sgbCellsX*8+row
+		for x := 0; x < 8; x++ {
+			data[i] |= (dmgShade(screen[y][x0+x]) >> plane & 1) << uint(7-x)
+		}
+	}
+	return data
+}
+
+func (s *sgb) receiveTransfer(data []byte) {
+	switch s.transfer &^ 0x80 {
+	case sgbPALTRN:
+		for i := range s.systemPalettes {
+			for c := range s.systemPalettes[i] {
+				s.systemPalettes[i][c] = rgb555(data[i*8+c*2:])
+			}
+		}
+	case sgbCHRTRN:
+		first := 0
+		if s.transfer&0x80 != 0 {
+			first = 128
+		}
+		for i := 0; i < 128; i++ {
+			copy(s.tiles[first+i][:], data[i*32:])
+		}
+	case sgbPCTTRN:
+		for i := range s.tileMap {
+			s.tileMap[i] = uint16(data[i*2]) | uint16(data[i*2+1])<<8
+		}
+		for i := range s.borderPalettes {
+			for c := range s.borderPalettes[i] {
+				s.borderPalettes[i][c] = rgb555(data[0x800+i*32+c*2:])
+			}
+		}
+	}
+	s.transfer = 0
+}
+
+// step composites a picture every time the PPU completes a frame
+func (s *sgb) step() {
+	if s.ppu.frames == s.frames {
+		return
+	}
+	s.frames = s.ppu.frames
+	if s.transfer != 0 && s.frames >= s.transferFrame {
+		s.receiveTransfer(capture(&s.ppu.front))
+	}
+	if s.mask != maskFreeze {
+		s.screen = s.ppu.front
+	}
+	s.composite()
+}
+
+// composite draws the screen with its palettes, then the border over it
+func (s *sgb) composite() {
+	color0 := s.palettes[0][0]
+	for y := range s.front {
+		for x := range s.front[y] {
+			s.front[y][x] = color0
+		}
+	}
+	for y := 0; y < ScreenHeight; y++ {
+		line := &s.front[sgbScreenY+y]
+		for x := 0; x < ScreenWidth; x++ {
+			switch s.mask {
+			case maskBlack:
+				line[sgbScreenX+x] = 0
+			case maskColor0:
+			default:
+				palette := s.attributes[y/8][x/8]
+				line[sgbScreenX+x] = s.palettes[palette][dmgShade(s.screen[y][x])]
+			}
+		}
+	}
+
+	// The border is 32x28 tiles of 16 colors, the color 0 is transparent
+	for i, entry := range s.tileMap {
+		tile := &s.tiles[entry&0xff]
+		palette := &s.borderPalettes[entry>>10&3]
+		for row := 0; row < 8; row++ {
+			r := row
+			if entry&0x8000 != 0 {
+				r = 7 - row
+			}
+			planes := [4]byte{tile[r*2], tile[r*2+1], tile[16+r*2], tile[16+r*2+1]}
+			for column := 0; column < 8; column++ {
+				bit := uint(7 - column)
+				if entry&0x4000 != 0 {
+					bit = uint(column)
+				}
+				var c byte
+				for p, plane := range planes {
+					c |= (plane >> bit & 1) << uint(p)
+				}
+				if c != 0 {
+					s.front[i/sgbMapWidth*8+row][i%sgbMapWidth*8+column] = palette[c]
+				}
+			}
+		}
+	}
+}
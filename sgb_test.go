@@ -0,0 +1,133 @@
+package goboy
+
+import "testing"
+
+// sendPacket sends a packet through P1, as the games do
+func sendPacket(j *joypad, packet []byte) {
+	j.write(0x00) // reset
+	j.write(0x30)
+	for i := 0; i < sgbPacketBits+1; i++ { // with the stop bit
+		if i < len(packet)*8 && packet[i/8]>>uint(i%8)&1 != 0 {
+			j.write(0x10)
+		} else {
+			j.write(0x20)
+		}
+		j.write(0x30)
+	}
+}
+
+func newTestSGB() (*sgb, *joypad) {
+	p := newTestPPU()
+	s := newSGB(p)
+	j := &joypad{irq: p.irq, sgb: s, selected: 0x30, lines: 0xf}
+	return s, j
+}
+
+// display returns a screen showing 4kB of data, for a VRAM transfer
+func display(data []byte) Frame {
+	var screen Frame
+	for y := range screen {
+		for x := range screen[y] {
+			screen[y][x] = dmgColors[0]
+		}
+	}
+	for i, b := range data {
+		tile, row, plane := i/16, i%16/2, uint(i%2)
+		x0, y := tile%sgbCellsX*8, tile/sgbCellsX*8+row
+		for x := 0; x < 8; x++ {
+			shade := dmgShade(screen[y][x0+x]) | (b>>uint(7-x)&1)<<plane
+			screen[y][x0+x] = dmgColors[shade]
+		}
+	}
+	return screen
+}
+
+func TestSGBPalettes(t *testing.T) {
+	s, j := newTestSGB()
+	// PAL01: color 0, palette 0 colors 1-3, palette 1 colors 1-3
+	sendPacket(j, []byte{sgbPAL01<<3 | 1, 0x1f, 0x00, 1, 0, 2, 0, 3, 0, 4, 0, 5, 0, 6, 0})
+	if s.palettes[0] != [4]uint16{0x1f, 1, 2, 3} || s.palettes[1] != [4]uint16{0x1f, 4, 5, 6} {
+		t.Error("Unexpected palettes", s.palettes[0], s.palettes[1])
+	}
+	if s.palettes[3][0] != 0x1f || s.palettes[3][1] != dmgColors[1] {
+		t.Error("Expected the color 0 to be shared", s.palettes[3])
+	}
+}
+
+func TestSGBMultiplayer(t *testing.T) {
+	s, j := newTestSGB()
+	sendPacket(j, []byte{sgbMLTREQ<<3 | 1, 1})
+	if s.players != 2 || j.read() != 0xff {
+		t.Error("Expected the first of 2 joypads, got", s.players, j.read())
+	}
+	// Reading the buttons selects the next joypad
+	j.write(0x20)
+	j.write(0x10)
+	j.write(0x30)
+	if id := j.read(); id != 0xfe {
+		t.Errorf("Expected the second joypad, got %#02x", id)
+	}
+}
+
+func TestSGBAttributes(t *testing.T) {
+	s, j := newTestSGB()
+	// ATTR_BLK: inside palette 1, border palette 2, outside palette 3
+	sendPacket(j, []byte{sgbATTRBLK<<3 | 1, 1, 7, 1<<0 | 2<<2 | 3<<4, 2, 2, 6, 6})
+	if s.attributes[4][4] != 1 || s.attributes[2][4] != 2 || s.attributes[0][0] != 3 {
+		t.Error("Unexpected block", s.attributes[4][4], s.attributes[2][4], s.attributes[0][0])
+	}
+	// ATTR_DIV: a horizontal line at row 9, palette 2 above and 1 below
+	sendPacket(j, []byte{sgbATTRDIV<<3 | 1, 0x40 | 3<<4 | 2<<2 | 1, 9})
+	if s.attributes[8][0] != 2 || s.attributes[9][5] != 3 || s.attributes[10][19] != 1 {
+		t.Error("Unexpected division")
+	}
+	// ATTR_LIN: column 3 with palette 0
+	sendPacket(j, []byte{sgbATTRLIN<<3 | 1, 1, 0<<5 | 3})
+	if s.attributes[0][3] != 0 || s.attributes[17][3] != 0 || s.attributes[0][4] != 2 {
+		t.Error("Unexpected line")
+	}
+	// ATTR_CHR: 5 cells from (18, 0), left to right
+	sendPacket(j, []byte{sgbATTRCHR<<3 | 1, 18, 0, 5, 0, 0, 0x1b, 0xc0})
+	if s.attributes[0][18] != 0 || s.attributes[0][19] != 1 || s.attributes[1][0] != 2 ||
+		s.attributes[1][1] != 3 || s.attributes[1][2] != 3 {
+		t.Error("Unexpected cells", s.attributes[0][18:], s.attributes[1][:3])
+	}
+}
+
+func TestSGBBorder(t *testing.T) {
+	s, j := newTestSGB()
+
+	// Tile 1 is filled with the color 15
+	tiles := make([]byte, 0x1000)
+	for i := 32; i < 64; i++ {
+		tiles[i] = 0xff
+	}
+	sendPacket(j, []byte{sgbCHRTRN<<3 | 1, 0})
+	s.ppu.front = display(tiles)
+	s.ppu.frames++
+	s.step()
+
+	// The top left tile of the border uses tile 1 and palette 5
+	picture := make([]byte, 0x1000)
+	picture[0], picture[1] = 1, 5<<2
+	picture[0x800+32+30], picture[0x800+32+31] = 0x1f, 0x00 // red
+	sendPacket(j, []byte{sgbPCTTRN<<3 | 1})
+	s.ppu.front = display(picture)
+	s.ppu.frames++
+	s.step()
+
+	if s.front[0][0] != 0x001f || s.front[8][8] != dmgColors[0] {
+		t.Errorf("Expected a red tile on the top left corner, got %#04x", s.front[0][0])
+	}
+	if s.front[sgbScreenY][sgbScreenX] != s.palettes[0][dmgShade(s.screen[0][0])] {
+		t.Error("Expected the screen of the Game Boy in the middle")
+	}
+
+	// MASK_EN blacks the screen out
+	sendPacket(j, []byte{sgbMASKEN<<3 | 1, maskBlack})
+	s.ppu.frames++
+	s.step()
+	if s.front[sgbScreenY][sgbScreenX] != 0 {
+		t.Error("Expected a black screen")
+	}
+}
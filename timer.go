@@ -0,0 +1,89 @@
+package goboy
+
+// Bit of the internal counter watched by TIMA, selected by TAC
+var timerBits = [4]uint16{1 << 9, 1 << 3, 1 << 5, 1 << 7}
+
+// timer emulates DIV and TIMA, both driven by a 16 bits internal counter.
+// TIMA is incremented on the falling edges of one of the counter bits,
+// which is why writes to DIV or TAC can increment it too.
+type timer struct {
+	irq      *interrupts
+	counter  uint16 // DIV is the upper byte
+	tima     byte
+	tma      byte
+	tac      byte
+	overflow bool // TIMA overflowed, it will be reloaded on the next cycle
+	reloaded bool // TIMA was reloaded during this cycle
+}
+
+// signal is the input of the falling edge detector
+func (t *timer) signal() bool {
+	return t.tac&4 != 0 && t.counter&timerBits[t.tac&3] != 0
+}
+
+func (t *timer) setCounter(value uint16) {
+	before := t.signal()
+	t.counter = value
+	if before && !t.signal() {
+		t.increment()
+	}
+}
+
+func (t *timer) increment() {
+	t.tima++
+	if t.tima == 0 {
+		t.overflow = true // TIMA reads 0 for a cycle before the reload
+	}
+}
+
+func (t *timer) step() {
+	t.reloaded = false
+	if t.overflow {
+		t.overflow = false
+		t.reloaded = true
+		t.tima = t.tma
+		t.irq.request(intTimer)
+	}
+	t.setCounter(t.counter + 4)
+}
+
+// resetDiv is done by writing DIV or executing STOP
+func (t *timer) resetDiv() {
+	t.setCounter(0)
+}
+
+func (t *timer) read(address uint16) byte {
+	switch address {
+	case 0xff04:
+		return byte(t.counter >> 8)
+	case 0xff05:
+		return t.tima
+	case 0xff06:
+		return t.tma
+	default:
+		return t.tac | 0xf8
+	}
+}
+
+func (t *timer) write(address uint16, value byte) {
+	switch address {
+	case 0xff04:
+		t.resetDiv()
+	case 0xff05:
+		if !t.reloaded { // the reload wins over the write
+			t.tima = value
+			t.overflow = false
+		}
+	case 0xff06:
+		t.tma = value
+		if t.reloaded {
+			t.tima = value
+		}
+	default:
+		before := t.signal()
+		t.tac = value & 7
+		if before && !t.signal() {
+			t.increment()
+		}
+	}
+}
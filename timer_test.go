@@ -0,0 +1,71 @@
+package goboy
+
+import "testing"
+
+func newTestTimer(tac byte) *timer {
+	t := &timer{irq: &interrupts{}}
+	t.write(0xff07, tac)
+	return t
+}
+
+func TestTimerFrequency(t *testing.T) {
+	tm := newTestTimer(5) // 262144 Hz, every 4 M-cycles
+	for i := 0; i < 64; i++ {
+		tm.step()
+	}
+	if tm.tima != 16 {
+		t.Error("Expected TIMA=16, got", tm.tima)
+	}
+	if div := tm.read(0xff04); div != 1 {
+		t.Error("Expected DIV=1, got", div)
+	}
+}
+
+func TestTimerOverflow(t *testing.T) {
+	tm := newTestTimer(5)
+	tm.tima = 0xff
+	tm.tma = 0x42
+	for i := 0; i < 4; i++ {
+		tm.step()
+	}
+	if tm.tima != 0 || tm.irq.flags != 0 {
+		t.Fatalf("Expected TIMA=0 without interrupt, got TIMA=%#02x IF=%#02x", tm.tima, tm.irq.flags)
+	}
+	tm.step()
+	if tm.tima != 0x42 || tm.irq.flags != intTimer {
+		t.Errorf("Expected TIMA=TMA with an interrupt, got TIMA=%#02x IF=%#02x", tm.tima, tm.irq.flags)
+	}
+	tm.write(0xff05, 0x10) // ignored during the reload cycle
+	if tm.tima != 0x42 {
+		t.Error("Expected the write to TIMA to be ignored, got", tm.tima)
+	}
+}
+
+func TestTimerOverflowCancelled(t *testing.T) {
+	tm := newTestTimer(5)
+	tm.tima = 0xff
+	for i := 0; i < 4; i++ {
+		tm.step()
+	}
+	tm.write(0xff05, 0x10)
+	tm.step()
+	if tm.tima != 0x10 || tm.irq.flags != 0 {
+		t.Errorf("Expected the reload to be cancelled, got TIMA=%#02x IF=%#02x", tm.tima, tm.irq.flags)
+	}
+}
+
+func TestTimerFallingEdges(t *testing.T) {
+	tm := newTestTimer(5)
+	tm.step()
+	tm.step() // bit 3 of the counter is now set
+	tm.write(0xff04, 0)
+	if tm.tima != 1 {
+		t.Error("Expected a write to DIV to increment TIMA, got", tm.tima)
+	}
+	tm.step()
+	tm.step()
+	tm.write(0xff07, 0) // disabling the timer is a falling edge too
+	if tm.tima != 2 {
+		t.Error("Expected a write to TAC to increment TIMA, got", tm.tima)
+	}
+}